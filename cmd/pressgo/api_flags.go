@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/fernando8franco/pressgo/internal/config"
+)
+
+// apiOptions tune how a command uses the API.
+type apiOptions struct {
+	retry   retryPolicy
+	workers int
+	// rateLimit is the most requests per second across all workers, 0 for
+	// no limit.
+	rateLimit float64
+}
+
+// apiFlags are the flags of the commands that call the API. Their defaults
+// come from the global config.
+type apiFlags struct {
+	attempts  *int
+	delay     *time.Duration
+	workers   *int
+	rateLimit *float64
+}
+
+func addAPIFlags(fs *flag.FlagSet, settings config.Settings) apiFlags {
+	retry := newRetryPolicy(settings)
+	workers := compressWorkers
+	if settings.Workers > 0 {
+		workers = settings.Workers
+	}
+
+	return apiFlags{
+		attempts:  fs.Int(maxAttemptsFlag, retry.maxAttempts, "Times each API step is tried before the pdf fails -max-attempts <n>\nThe default can be changed with 'pressgo config -set "+config.SettingMaxAttempts+" <n>'."),
+		delay:     fs.Duration(retryDelayFlag, retry.baseDelay, "Wait before the first retry, doubled on each next one -retry-delay <duration>\nThe default can be changed with 'pressgo config -set "+config.SettingRetryDelay+" <duration>'."),
+		workers:   fs.Int(workersFlag, workers, "Pdfs compressed at the same time -workers <n>\nThe default can be changed with 'pressgo config -set "+config.SettingWorkers+" <n>'."),
+		rateLimit: fs.Float64(rateLimitFlag, settings.RateLimit, "Most API requests per second across all workers, 0 for no limit -rate-limit <n>\nThe default can be changed with 'pressgo config -set "+config.SettingRateLimit+" <n>'."),
+	}
+}
+
+func (f apiFlags) options() (apiOptions, error) {
+	opts := apiOptions{
+		retry:     retryPolicy{maxAttempts: *f.attempts, baseDelay: *f.delay},
+		workers:   *f.workers,
+		rateLimit: *f.rateLimit,
+	}
+
+	if err := opts.retry.validate(); err != nil {
+		return apiOptions{}, err
+	}
+	if opts.workers < 1 {
+		return apiOptions{}, fmt.Errorf("-%s must be greater than 0", workersFlag)
+	}
+	if opts.rateLimit < 0 {
+		return apiOptions{}, fmt.Errorf("-%s can't be negative", rateLimitFlag)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+var zipHeader = []byte("PK\x03\x04")
+
+// isZip reports whether the file at path is a zip archive. The API sends
+// one when a task has more than one output.
+func isZip(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, len(zipHeader))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(header, zipHeader), nil
+}
+
+// unzipEach calls f with the files of the zip archive at path, in the order
+// they are stored. Directories are skipped. Entry names come from the API,
+// so f must not use them as paths as they are.
+func unzipEach(path string, f func(name string, r io.Reader) error) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || strings.HasSuffix(entry.Name, "/") {
+			continue
+		}
+
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = f(entry.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipNames returns the names of the files of the zip archive at path, in the
+// order they are stored. Directories are skipped.
+func zipNames(path string) ([]string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var names []string
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || strings.HasSuffix(entry.Name, "/") {
+			continue
+		}
+		names = append(names, entry.Name)
+	}
+	return names, nil
+}
+
+// writeFile copies r to path through a part file, so an interrupted copy
+// never leaves a partial output behind.
+func writeFile(path string, r io.Reader) error {
+	part := path + partExt
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(part)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(part)
+		return err
+	}
+
+	return os.Rename(part, path)
+}
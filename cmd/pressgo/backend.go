@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fernando8franco/pressgo/internal/journal"
+)
+
+// backend compresses a single pdf. It writes the result to dst and may record
+// intermediate journal states so an interrupted run can pick up from them.
+type backend interface {
+	compress(ctx context.Context, pdf PDFsConfig, src, dst string) (usage, error)
+}
+
+// unlocker is a backend that can remove the password of an encrypted pdf
+// before compressing it.
+type unlocker interface {
+	unlock(ctx context.Context, src, password, dst string) (usage, error)
+}
+
+// usage is what compressing a pdf cost: the credential that processed it and
+// the credits charged during this run.
+type usage struct {
+	credentialID string
+	credits      int
+	// retries counts the API calls that were tried again after a transient
+	// error.
+	retries int
+}
+
+func newBackend(ctx context.Context, s *state, name string, jr *journal.Journal, retry retryPolicy) (backend, error) {
+	switch name {
+	case backendILovePDF:
+		return newILovePDFBackend(ctx, s, jr, retry)
+	case backendLocal:
+		return localBackend{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown backend %q, use %q or %q", name, backendILovePDF, backendLocal)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+	"github.com/fernando8franco/pressgo/internal/journal"
+)
+
+// ilovePDFBackend compresses pdfs with the iLovePDF compress tool, switching
+// credentials when the active one runs out of credits.
+type ilovePDFBackend struct {
+	ss    *session
+	jr    *journal.Journal
+	retry retryPolicy
+}
+
+func newILovePDFBackend(ctx context.Context, s *state, jr *journal.Journal, retry retryPolicy) (*ilovePDFBackend, error) {
+	ss, err := newSession(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ilovePDFBackend{ss: ss, jr: jr, retry: retry}, nil
+}
+
+// apiStep runs one step of a task with the client of credential id, retrying
+// transient failures as the retry policy allows and refreshing an expired
+// token.
+func apiStep[T any](ctx context.Context, b *ilovePDFBackend, name, id string, api **iloveapi.Client, u *usage, call func(context.Context, *iloveapi.Client) (T, error)) (T, error) {
+	return withRetries(ctx, b.retry, name, &u.retries, func(ctx context.Context) (T, error) {
+		return callWithRetry(ctx, b.ss, id, api, call)
+	})
+}
+
+func (b *ilovePDFBackend) compress(ctx context.Context, pdf PDFsConfig, src, dst string) (usage, error) {
+	var u usage
+	err := withFailover(ctx, b.ss, func(id string, api *iloveapi.Client) error {
+		return b.compressWith(ctx, id, api, pdf, src, dst, &u)
+	})
+	u.credentialID = b.jr.Get(pdf.Filename, pdf.NewName).CredentialID
+	return u, err
+}
+
+// compressWith moves a pdf through the journal states, starting from the
+// last state recorded so a resumed run never processes a task twice. The
+// credit charged by processing the task is added to u.
+func (b *ilovePDFBackend) compressWith(ctx context.Context, id string, api *iloveapi.Client, pdf PDFsConfig, src, dst string, u *usage) error {
+	// src may be an unlocked temp copy, so the api is given the name of the
+	// pdf instead of the one on disk.
+	filename := filepath.Base(pdf.Filename)
+
+	entry := b.jr.Get(pdf.Filename, pdf.NewName)
+	switch entry.State {
+	case journal.Uploaded:
+		// Nothing was charged yet, so a task of another credential is started again.
+		if entry.CredentialID != id {
+			entry.State = journal.Pending
+		}
+	case journal.Processed:
+		if entry.CredentialID != id {
+			var err error
+			api, err = b.ss.clientFor(ctx, entry.CredentialID)
+			if err != nil {
+				return err
+			}
+			id = entry.CredentialID
+		}
+	}
+
+	if entry.State == journal.Pending {
+		start, err := apiStep(ctx, b, stepStart, id, &api, u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.StartResponse, error) {
+			return api.Start(ctx, iloveapi.StartParams{Tool: toolCompress, Region: region})
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := b.ss.setCredits(id, start.RemainingCredits); err != nil {
+			return err
+		}
+		if start.RemainingCredits <= 0 {
+			return errCreditsExhausted
+		}
+
+		upload, err := apiStep(ctx, b, stepUpload, id, &api, u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.UploadResponse, error) {
+			file, err := os.Open(src)
+			if err != nil {
+				return iloveapi.UploadResponse{}, err
+			}
+			defer file.Close()
+
+			return api.Upload(ctx, iloveapi.UploadParams{
+				Server:   start.Server,
+				Task:     start.Task,
+				File:     file,
+				FileName: filename,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		entry.State = journal.Uploaded
+		entry.CredentialID = id
+		entry.Server = start.Server
+		entry.Task = start.Task
+		entry.ServerFilename = upload.ServerFilename
+		if err := b.jr.Update(entry); err != nil {
+			return err
+		}
+	}
+
+	if entry.State == journal.Uploaded {
+		_, err := apiStep(ctx, b, stepProcess, id, &api, u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.ProcessResponse, error) {
+			return api.Process(ctx, iloveapi.ProcessParams{
+				Server: entry.Server,
+				Task:   entry.Task,
+				Tool:   toolCompress,
+				Files: []iloveapi.File{
+					{
+						ServerFilename: entry.ServerFilename,
+						Filename:       filename,
+					},
+				},
+				Meta: iloveapi.Meta{
+					Title:  pdf.Title,
+					Author: pdf.Author,
+				},
+				Options: map[string]any{
+					"compression_level": pdf.CompressionLevel,
+				},
+			})
+		})
+		if err != nil {
+			return err
+		}
+		u.credits++
+
+		entry.State = journal.Processed
+		if err := b.jr.Update(entry); err != nil {
+			return err
+		}
+	}
+
+	// The whole transfer is retried, a connection can drop halfway through.
+	_, err := apiStep(ctx, b, stepDownload, id, &api, u, func(ctx context.Context, api *iloveapi.Client) (struct{}, error) {
+		return struct{}{}, downloadPDF(ctx, api, entry.Server, entry.Task, dst)
+	})
+	return err
+}
+
+func downloadPDF(ctx context.Context, api *iloveapi.Client, server, task, dst string) error {
+	download, err := api.Download(ctx, iloveapi.DownloadParams{Server: server, Task: task})
+	if err != nil {
+		return err
+	}
+	defer download.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, download); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
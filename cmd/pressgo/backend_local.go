@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfdoc"
+)
+
+// localLevels maps the compression levels to the image settings of the local
+// backend; streams are always recompressed as much as possible.
+var localLevels = map[string]pdfdoc.Options{
+	levelLow:         {ImageQuality: 90, MaxImageSize: 3000},
+	levelRecommended: {ImageQuality: pdfdoc.DefaultImageQuality, MaxImageSize: pdfdoc.DefaultMaxImageSize},
+	levelExtreme:     {ImageQuality: 50, MaxImageSize: 1200},
+}
+
+// localBackend compresses pdfs offline with pdfdoc, without credentials or
+// network access.
+type localBackend struct{}
+
+func (localBackend) compress(ctx context.Context, pdf PDFsConfig, src, dst string) (usage, error) {
+	if err := ctx.Err(); err != nil {
+		return usage{}, err
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return usage{}, err
+	}
+
+	opts := localLevels[pdf.CompressionLevel]
+	opts.Title = pdf.Title
+	opts.Author = pdf.Author
+
+	compressed, err := pdfdoc.Optimize(data, opts)
+	if err != nil {
+		return usage{}, err
+	}
+
+	return usage{}, os.WriteFile(dst, compressed, 0644)
+}
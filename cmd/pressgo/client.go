@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/time/rate"
+)
+
+// newHTTPClient returns the client used for every API call. When
+// PRESSGO_API_URL is set, all requests, including the ones to the task
+// servers the API hands out, are sent to that base URL instead.
+func newHTTPClient() (*http.Client, error) {
+	baseURL := os.Getenv(apiURLEnv)
+	if baseURL == "" {
+		return &http.Client{
+			Transport: &retryAfterTransport{next: http.DefaultTransport},
+		}, nil
+	}
+
+	return newBaseURLClient(baseURL)
+}
+
+func newBaseURLClient(baseURL string) (*http.Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q", apiURLEnv, baseURL)
+	}
+
+	return &http.Client{
+		Transport: &retryAfterTransport{
+			next: &baseURLTransport{base: base, next: http.DefaultTransport},
+		},
+	}, nil
+}
+
+type baseURLTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.base.Scheme
+	r.URL.Host = t.base.Host
+	r.URL.Path = t.base.Path + req.URL.Path
+	r.Host = ""
+
+	return t.next.RoundTrip(r)
+}
+
+// withRateLimit returns a copy of client whose requests, from all the
+// goroutines that share it, never go over perSecond. A limit of 0 returns
+// client as is.
+func withRateLimit(client *http.Client, perSecond float64) *http.Client {
+	if perSecond <= 0 {
+		return client
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	limited := *client
+	limited.Transport = &rateLimitTransport{
+		limiter: rate.NewLimiter(rate.Limit(perSecond), 1),
+		next:    next,
+	}
+	return &limited
+}
+
+// rateLimitTransport holds each request until the token bucket it shares
+// with the other workers has a token for it.
+type rateLimitTransport struct {
+	limiter *rate.Limiter
+	next    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -1,18 +1,28 @@
 package main
 
+import (
+	"context"
+	"fmt"
+)
+
 type command struct {
 	Name      string
 	Arguments []string
 }
 
 type commands struct {
-	registeredCommands map[string]func(*state, command) error
+	registeredCommands map[string]func(context.Context, *state, command) error
 }
 
-func (c *commands) Run(s *state, cmd command) error {
-	return c.registeredCommands[cmd.Name](s, cmd)
+func (c *commands) Run(ctx context.Context, s *state, cmd command) error {
+	handler, ok := c.registeredCommands[cmd.Name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", cmd.Name)
+	}
+
+	return handler(ctx, s, cmd)
 }
 
-func (c *commands) Register(name string, f func(*state, command) error) {
+func (c *commands) Register(name string, f func(context.Context, *state, command) error) {
 	c.registeredCommands[name] = f
 }
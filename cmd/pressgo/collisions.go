@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/pkg/slug"
+)
+
+func validCollisionStrategy(strategy string) bool {
+	return strategy == collisionSuffix || strategy == collisionParent || strategy == collisionHash
+}
+
+// resolveCollisions renames the pdfs whose new names are shared with others,
+// since their outputs would overwrite each other. Names are compared ignoring
+// case, like most file systems do. With the suffix strategy the first pdf
+// keeps its name and the rest get -2, -3...; with parent and hash every pdf
+// of the group is prefixed with its directory or suffixed with a short hash
+// of its path. Whatever is still shared after that falls back to a suffix.
+func resolveCollisions(pdfs []PDFsConfig, strategy string) {
+	groups := map[string][]int{}
+	for i, pdf := range pdfs {
+		key := strings.ToLower(pdf.NewName)
+		groups[key] = append(groups[key], i)
+	}
+
+	taken := map[string]bool{}
+	for _, pdf := range pdfs {
+		taken[strings.ToLower(pdf.NewName)] = true
+	}
+
+	for i, pdf := range pdfs {
+		if len(groups[strings.ToLower(pdf.NewName)]) < 2 {
+			continue
+		}
+
+		switch strategy {
+		case collisionParent:
+			parent := slug.Create(filepath.Base(filepath.Dir(pdf.Filename)))
+			if parent != "" {
+				pdfs[i].NewName = parent + "-" + pdf.NewName
+			}
+		case collisionHash:
+			sum := sha256.Sum256([]byte(filepath.ToSlash(pdf.Filename)))
+			pdfs[i].NewName = withNameSuffix(pdf.NewName, hex.EncodeToString(sum[:])[:shortHashLen])
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, pdf := range pdfs {
+		key := strings.ToLower(pdf.NewName)
+		if !seen[key] {
+			seen[key] = true
+			taken[key] = true
+			continue
+		}
+
+		for n := 2; ; n++ {
+			name := withNameSuffix(pdf.NewName, fmt.Sprint(n))
+			key := strings.ToLower(name)
+			if !taken[key] {
+				pdfs[i].NewName = name
+				seen[key] = true
+				taken[key] = true
+				break
+			}
+		}
+	}
+}
+
+// withNameSuffix adds -suffix to name, before its extension.
+func withNameSuffix(name, suffix string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + suffix + ext
+}
@@ -1,17 +1,122 @@
 package main
 
+import "time"
+
 const (
 	credentialsCmd = "credentials"
 	compressCmd    = "compress"
+	watchCmd       = "watch"
+	configCmd      = "config"
+	mergeCmd       = "merge"
+	splitCmd       = "split"
+	watermarkCmd   = "watermark"
+	protectCmd     = "protect"
+	unlockCmd      = "unlock"
+	imagePDFCmd    = "imagepdf"
+	pdfJPGCmd      = "pdfjpg"
 
 	initHelpFlag = "help"
 	noInitFlag   = "no-init"
 	initFlag     = "init"
+	resumeFlag   = "resume"
+	backendFlag  = "backend"
+	levelFlag    = "level"
+
+	keepOriginalsFlag    = "keep-originals"
+	replaceIfSmallerFlag = "replace-if-smaller"
+	reportFlag           = "report"
+	dryRunFlag           = "dry-run"
+	overwriteFlag        = "overwrite"
+	collisionsFlag       = "collisions"
+
+	outFlag      = "out"
+	nameFlag     = "name"
+	compressFlag = "compress"
+	rangesFlag   = "ranges"
+	everyFlag    = "every"
+	extractFlag  = "extract"
+	textFlag     = "text"
+	imageFlag    = "image"
+	positionFlag = "position"
+	opacityFlag  = "opacity"
+	rotationFlag = "rotation"
+	pagesFlag    = "pages"
+	passwordFlag = "password"
+	orientFlag   = "orientation"
+	marginFlag   = "margin"
+	pageSizeFlag = "page-size"
+	mergeFlag    = "merge"
+	modeFlag     = "mode"
+	mirrorFlag   = "mirror"
+	slugDirsFlag = "slug-dirs"
+	titleFlag    = "title"
+	authorFlag   = "author"
+	intervalFlag = "interval"
+	pollFlag     = "poll"
+
+	maxAttemptsFlag = "max-attempts"
+	retryDelayFlag  = "retry-delay"
+	workersFlag     = "workers"
+	rateLimitFlag   = "rate-limit"
+
+	includeFlag  = "include"
+	excludeFlag  = "exclude"
+	maxDepthFlag = "max-depth"
+	hiddenFlag   = "hidden"
+
+	extensionlessFlag = "extensionless"
+	minSizeFlag       = "min-size"
+
+	apiURLEnv = "PRESSGO_API_URL"
+	// passwordEnv is the password of the encrypted pdfs without one in the
+	// config file.
+	passwordEnv = "PRESSGO_PDF_PASSWORD"
+
+	exitError = 1
+	// exitInterrupted follows the shell convention of 128 + SIGINT.
+	exitInterrupted = 130
+
+	toolCompress    = "compress"
+	toolMerge       = "merge"
+	toolSplit       = "split"
+	toolWatermark   = "watermark"
+	toolProtect     = "protect"
+	toolUnlock      = "unlock"
+	toolImagePDF    = "imagepdf"
+	toolPDFJPG      = "pdfjpg"
+	region          = "us"
+	compressWorkers = 3
+
+	stepStart    = "start"
+	stepUpload   = "upload"
+	stepProcess  = "process"
+	stepDownload = "download"
+
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = time.Second
+	maxRetryDelay      = 30 * time.Second
+	maxRetryAfter      = 2 * time.Minute
+
+	levelLow         = "low"
+	levelRecommended = "recommended"
+	levelExtreme     = "extreme"
+
+	collisionSuffix = "suffix"
+	collisionParent = "parent"
+	collisionHash   = "hash"
+	shortHashLen    = 8
 
-	toolCompress = "compress"
-	region       = "us"
+	backendILovePDF = "ilovepdf"
+	backendLocal    = "local"
 
 	configFile    = "pressgo.config.json"
+	journalFile   = "pressgo.journal.json"
+	cacheFile     = "pressgo.cache.json"
 	pdfExt        = ".pdf"
+	partExt       = ".part"
 	titleFilename = "base"
+	watchOutDir   = "compressed"
+	mergeName     = "merged"
+	imagesName    = "images"
+	jpgExt        = ".jpg"
 )
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+// patternsFlag is a flag that can be given more than once, each time with
+// one or more comma separated patterns.
+type patternsFlag []string
+
+func (p *patternsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternsFlag) Set(value string) error {
+	for pattern := range strings.SplitSeq(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*p = append(*p, pattern)
+		}
+	}
+	return nil
+}
+
+// sizeFlag is a size in bytes, given as a number with an optional B, KB, MB
+// or GB unit.
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return formatBytes(int64(*f))
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(size)
+	return nil
+}
+
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  float64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+
+	number, unit := strings.ToUpper(strings.TrimSpace(value)), 1.0
+	for _, u := range units {
+		if strings.HasSuffix(number, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, use a number of bytes or a size like 40KB or 2MB", value)
+	}
+	return int64(n * unit), nil
+}
+
+// discoveryFlags are the flags of the commands that look for pdfs in a
+// directory.
+type discoveryFlags struct {
+	include       *patternsFlag
+	exclude       *patternsFlag
+	maxDepth      *int
+	hidden        *bool
+	extensionless *bool
+	minSize       *sizeFlag
+}
+
+func addDiscoveryFlags(fs *flag.FlagSet) discoveryFlags {
+	f := discoveryFlags{include: &patternsFlag{}, exclude: &patternsFlag{}, minSize: new(sizeFlag)}
+	fs.Var(f.include, includeFlag, "Only use the pdfs matching a pattern -include <pattern,...>\nPatterns follow the "+pdfs.IgnoreFile+" syntax and the flag can be repeated.")
+	fs.Var(f.exclude, excludeFlag, "Leave out the files and directories matching a pattern -exclude <pattern,...>\nPaths listed in a "+pdfs.IgnoreFile+" file, with the syntax of a .gitignore, are always left out.")
+	f.maxDepth = fs.Int(maxDepthFlag, 0, "How deep pdfs are looked for, 1 being the directory itself -max-depth <n>\n0 means no limit.")
+	f.hidden = fs.Bool(hiddenFlag, false, "Also look inside directories whose name starts with a dot -hidden")
+	f.extensionless = fs.Bool(extensionlessFlag, false, "Also use files without an extension whose content is a pdf -extensionless")
+	fs.Var(f.minSize, minSizeFlag, "Leave out the pdfs smaller than a size -min-size <size>\nLike 40KB or 2MB, too small to be worth a credit.")
+	return f
+}
+
+func (f discoveryFlags) options() (pdfs.Options, error) {
+	if *f.maxDepth < 0 {
+		return pdfs.Options{}, fmt.Errorf("-%s can't be negative", maxDepthFlag)
+	}
+
+	opts := pdfs.Options{
+		Include:       *f.include,
+		Exclude:       *f.exclude,
+		MaxDepth:      *f.maxDepth,
+		Hidden:        *f.hidden,
+		Extensionless: *f.extensionless,
+		MinSize:       int64(*f.minSize),
+	}
+	// Invalid patterns are reported before any work is done.
+	if _, err := pdfs.NewFilter("", opts); err != nil {
+		return pdfs.Options{}, fmt.Errorf("invalid pattern: %v", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/internal/journal"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	dryRunReady             = "ready"
+	dryRunMissing           = "missing"
+	dryRunCollision         = "collision"
+	dryRunExists            = "output exists"
+	dryRunSkipped           = "skipped"
+	dryRunAlreadyCompressed = "already compressed"
+)
+
+// dryRunPlan is what a compress run of the manifest would do, without
+// touching the files or the API.
+type dryRunPlan struct {
+	rows       [][]string
+	missing    int
+	collisions int
+	existing   int
+	// needed are the credits the run would use, one per pdf to compress
+	// and one more per pdf to unlock.
+	needed    int
+	available int
+}
+
+// planDryRun checks every pdf of the manifest: its source must exist and its
+// output can't be shared with another pdf, or one would overwrite the other,
+// or replace a file that already exists unless opts.overwrite is set. Paths
+// are compared ignoring case, like most file systems do.
+func planDryRun(s *state, manifest []PDFsConfig, jr *journal.Journal, backend string, opts compressOptions) dryRunPlan {
+	owners := map[string][]string{}
+	for _, pdf := range manifest {
+		key := strings.ToLower(outputPath(s, pdf, opts))
+		owners[key] = append(owners[key], pdf.Filename)
+	}
+
+	var p dryRunPlan
+	for _, pdf := range manifest {
+		status := dryRunReady
+		output := outputPath(s, pdf, opts)
+		entry := jr.Get(pdf.Filename, pdf.NewName)
+		switch {
+		case entry.State == journal.Verified:
+			status = dryRunAlreadyCompressed
+		case len(owners[strings.ToLower(output)]) > 1:
+			status = dryRunCollision
+			p.collisions++
+		case entry.State != journal.Downloaded && !exists(resolvePath(s, pdf.Filename)):
+			status = dryRunMissing
+			p.missing++
+		case (entry.State == journal.Pending || entry.State == journal.Uploaded) && !opts.overwrite &&
+			overwrites(resolvePath(s, pdf.Filename), output):
+			status = dryRunExists
+			p.existing++
+		case entry.State != journal.Downloaded:
+			if reason, err := skipReason(s, pdf, opts); err == nil && reason != "" {
+				status = dryRunSkipped + ", " + reason
+			}
+		}
+
+		// Tasks already processed were paid for by the run that started them.
+		if (status == dryRunReady || status == dryRunCollision) && backend == backendILovePDF &&
+			entry.State != journal.Processed && entry.State != journal.Downloaded {
+			p.needed++
+			// Encrypted pdfs are unlocked first, which is charged too.
+			if (entry.State == journal.Pending || entry.State == journal.Uploaded) && !hasUnlockedCopy(entry) {
+				if encrypted, err := pdfs.Encrypted(resolvePath(s, pdf.Filename)); err == nil && encrypted {
+					p.needed++
+				}
+			}
+		}
+
+		if rel, err := filepath.Rel(s.wdir, output); err == nil && !strings.HasPrefix(rel, "..") {
+			output = rel
+		}
+		p.rows = append(p.rows, []string{
+			pdf.Filename, output, pdf.Title, pdf.Author, pdf.CompressionLevel, status,
+		})
+	}
+
+	if backend == backendILovePDF {
+		p.available = s.cfg.AvailableCredits()
+	}
+	return p
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// dryRunPDFs prints the plan of a compress run and returns an error when the
+// run couldn't complete.
+func dryRunPDFs(s *state, pdfs []PDFsConfig, jr *journal.Journal, backend string, opts compressOptions) error {
+	p := planDryRun(s, pdfs, jr, backend, opts)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"File", "Output", "Title", "Author", "Level", "Status"})
+	table.Bulk(p.rows)
+	table.Render()
+
+	if backend == backendILovePDF {
+		fmt.Printf("Credits needed: %d, available across all credentials: %d\n", p.needed, p.available)
+	}
+
+	var problems []string
+	if p.missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d pdfs are missing", p.missing))
+	}
+	if p.collisions > 0 {
+		problems = append(problems, fmt.Sprintf("%d pdfs share their new name with another one", p.collisions))
+	}
+	if p.existing > 0 {
+		problems = append(problems, fmt.Sprintf("%d new names already exist, use -%s to replace them", p.existing, overwriteFlag))
+	}
+	if p.needed > p.available {
+		problems = append(problems, fmt.Sprintf("%d more credits are needed", p.needed-p.available))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the run can't complete: %s", strings.Join(problems, ", "))
+	}
+
+	fmt.Println("Dry run finished, no files were changed")
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,17 +11,33 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/fernando8franco/pressgo/internal/cache"
+	"github.com/fernando8franco/pressgo/internal/journal"
 	"github.com/fernando8franco/pressgo/pkg/pdfs"
 	"github.com/fernando8franco/pressgo/pkg/slug"
 )
 
-func HandlerCompress(s *state, cmd command) error {
+func HandlerCompress(ctx context.Context, s *state, cmd command) error {
 	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
 	var (
-		help = fs.Bool(initHelpFlag, false, "Show help message")
-		init = fs.Bool(initFlag, false, "Create config file -init <title> <author>\nIf title == 'base', all filenames default to the base name.")
+		help       = fs.Bool(initHelpFlag, false, "Show help message")
+		init       = fs.Bool(initFlag, false, "Create config file -init <author> <title>\nIf title == 'base', all filenames default to the base name.")
+		resume     = fs.Bool(resumeFlag, false, "Resume an interrupted compress run -resume")
+		backend    = fs.String(backendFlag, backendILovePDF, "Compression backend -backend <ilovepdf|local>\nThe local backend works offline and needs no credentials.")
+		keep       = fs.Bool(keepOriginalsFlag, false, "Keep the original pdfs next to the compressed ones -keep-originals")
+		smaller    = fs.Bool(replaceIfSmallerFlag, false, "Keep the original, renamed, when the compressed file isn't smaller -replace-if-smaller")
+		report     = fs.String(reportFlag, "", "Save a report of the run -report <report.json|report.csv>")
+		overwrite  = fs.Bool(overwriteFlag, false, "Replace files that already have the new name of a pdf -overwrite")
+		collisions = fs.String(collisionsFlag, collisionSuffix, "How -init renames pdfs that would get the same new name -collisions <suffix|parent|hash>\nsuffix numbers them, parent prefixes their directory and hash adds a short hash of their path.")
+		out        = fs.String(outFlag, "", "Directory the compressed pdfs are written to -out <dir>\nDefaults to the current directory.")
+		mirror     = fs.Bool(mirrorFlag, false, "Keep the directory of each pdf, relative to the current one, inside the output directory -mirror")
+		slugDirs   = fs.Bool(slugDirsFlag, false, "Slugify the names of the mirrored directories -slug-dirs")
+		dryRun     = fs.Bool(dryRunFlag, false, "Show what a run would do and the credits it needs, without compressing -dry-run")
+		level      = fs.String(levelFlag, levelRecommended, "Default compression level written by -init -level <low|recommended|extreme>\nEach file can override it with compression_level in the config file.")
 		// noInit = fs.Bool(noInitFlag, false, "Compress files without config file -no-init")
 	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	discovery := addDiscoveryFlags(fs)
 	fs.Parse(cmd.Arguments)
 
 	if *help {
@@ -28,209 +45,175 @@ func HandlerCompress(s *state, cmd command) error {
 		return nil
 	}
 
+	discoveryOpts, err := discovery.options()
+	if err != nil {
+		return err
+	}
+
 	if *init {
 		cmd.Arguments = fs.Args()
 		if len(cmd.Arguments) != 2 {
-			fmt.Printf("Error: -init requires exactly two arguments: title and author.\nUsage: pressgo -init <title> <author>\n")
+			fmt.Printf("Error: -init requires exactly two arguments: author and title.\nUsage: pressgo -init <author> <title>\n")
 			os.Exit(1)
 		}
 
-		initConfig(s, cmd)
-	}
-
-	// if _, err := os.Stat(s.paths.configPDFsFile); errors.Is(err, os.ErrNotExist) {
-	// 	fmt.Printf("PDF's config file not found\nTry '%s' first.\n", initCmd)
-	// 	return nil
-	// }
-
-	// pdfs, err := getConfigPdfsFile(s.paths.configPDFsFile)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// keyInfo := s.cfg.GetKeyInfo()
-	// client := &http.Client{}
-	// iloveapi := ilApi.NewClient(client, keyInfo.Key, keyInfo.Token)
-
-	// pdfsChannel := make(chan PDFsConfig)
-	// var wg errgroup.Group
-	// for range 3 {
-	// 	wg.Go(func() error {
-	// 		for pdf := range pdfsChannel {
-	// 			fmt.Println("Compressing:", pdf.Filename)
-
-	// 			startResponse, err := callWithRetry(s, iloveapi, func() (ilApi.StartResponse, error) {
-	// 				return iloveapi.Start(s.cfg.GetKeyInfo().Token, toolCompress, region)
-	// 			})
-	// 			if err != nil {
-	// 				return err
-	// 			}
-
-	// 			server := startResponse.Server
-	// 			task := startResponse.Task
-	// 			pdfFile := filepath.Join(s.paths.pdfsDir, pdf.Filename)
-	// 			file, err := os.Open(pdfFile)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 			defer file.Close()
-
-	// 			uploadResponse, err := callWithRetry(s, iloveapi, func() (ilApi.UploadResponse, error) {
-	// 				return iloveapi.Upload(s.cfg.GetKeyInfo().Token, server, ilApi.UploadRequest{
-	// 					Task:     task,
-	// 					File:     file,
-	// 					FileName: pdf.Filename,
-	// 				})
-	// 			})
-	// 			if err != nil {
-	// 				return err
-	// 			}
-
-	// 			serverFilename := uploadResponse.ServerFilename
-	// 			_, err = callWithRetry(s, iloveapi, func() (ilApi.ProcessResponse, error) {
-	// 				return iloveapi.Process(s.cfg.GetKeyInfo().Token, server, ilApi.ProcessRequest{
-	// 					Task: task,
-	// 					Tool: toolCompress,
-	// 					Files: []ilApi.Files{
-	// 						{
-	// 							ServerFileName: serverFilename,
-	// 							FileName:       pdf.Filename,
-	// 						},
-	// 					},
-	// 					Meta: ilApi.Meta{
-	// 						Title:  pdf.Title,
-	// 						Author: pdf.Author,
-	// 					},
-	// 				})
-	// 			})
-	// 			if err != nil {
-	// 				return err
-	// 			}
-
-	// 			compressPdfPath := filepath.Join(s.paths.compressPdfsDir, pdf.NewName)
-	// 			out, err := os.Create(compressPdfPath)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 			defer out.Close()
-
-	// 			dowloadResponse, err := callWithRetry(s, iloveapi, func() (io.ReadCloser, error) {
-	// 				return iloveapi.Dowload(s.cfg.GetKeyInfo().Token, server, task, out)
-	// 			})
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 			defer dowloadResponse.Close()
-
-	// 			_, err = io.Copy(out, dowloadResponse)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-
-	// 			err = os.Remove(pdfFile)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 			fmt.Println(pdf.Filename, "--- Compressed correctly")
-	// 		}
-
-	// 		return nil
-	// 	})
-	// }
-
-	// go func() {
-	// 	defer close(pdfsChannel)
-	// 	for _, info := range pdfs {
-	// 		filePath := filepath.Join(s.paths.pdfsDir, info.Filename)
-	// 		if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
-	// 			continue
-	// 		}
-	// 		pdfsChannel <- info
-	// 	}
-	// }()
-
-	// if err := wg.Wait(); err != nil {
-	// 	return err
-	// } else {
-	// 	fmt.Println("All pdfs were compressed correctly")
-	// }
-
-	// err = os.Remove(s.paths.configPDFsFile)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// return nil
+		if !validCompressionLevel(*level) {
+			return fmt.Errorf("invalid compression level %q, use %s, %s or %s", *level, levelLow, levelRecommended, levelExtreme)
+		}
+		if !validCollisionStrategy(*collisions) {
+			return fmt.Errorf("invalid collision strategy %q, use %s, %s or %s", *collisions, collisionSuffix, collisionParent, collisionHash)
+		}
+
+		return initConfig(s, manifestOptions{
+			title:      cmd.Arguments[1],
+			author:     cmd.Arguments[0],
+			level:      *level,
+			collisions: *collisions,
+			discovery:  discoveryOpts,
+		})
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	if *report != "" && !validReportPath(*report) {
+		return fmt.Errorf("invalid report file %q, use a .json or .csv extension", *report)
+	}
+
+	configFile := filepath.Join(s.wdir, configFile)
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("PDF's config file not found\nTry '%s -%s <author> <title>' first.\n", compressCmd, initFlag)
+		return nil
+	}
+
+	pdfs, err := getConfigPdfsFile(configFile)
+	if err != nil {
+		return err
+	}
+	if pdfs, err = filterPDFs(s, pdfs, discoveryOpts); err != nil {
+		return err
+	}
+
+	journalFile := filepath.Join(s.wdir, journalFile)
+	jr, err := journal.Open(journalFile)
+	if err != nil {
+		return fmt.Errorf("error reading journal file: %v", err)
+	}
+
+	if !*resume {
+		if len(jr.Unfinished()) > 0 && !*dryRun {
+			fmt.Printf("An unfinished compress run was found.\nUse '%s -%s' to continue it or delete %s to start over.\n", compressCmd, resumeFlag, journalFile)
+			return nil
+		}
+		jr = journal.New(journalFile)
+	}
+
+	c, err := cache.Open(filepath.Join(s.wdir, cacheFile))
+	if err != nil {
+		return fmt.Errorf("error reading cache file: %v", err)
+	}
+
+	opts := compressOptions{
+		keepOriginals:    *keep,
+		replaceIfSmaller: *smaller,
+		workers:          apiOpts.workers,
+		overwrite:        *overwrite,
+		out:              *out,
+		mirror:           *mirror,
+		slugDirs:         *slugDirs,
+		minSize:          discoveryOpts.MinSize,
+		cache:            c,
+		unlock:           *backend == backendILovePDF,
+	}
+
+	if *dryRun {
+		return dryRunPDFs(s, pdfs, jr, *backend, opts)
+	}
+
+	// Only the backend calls the API, so only its requests are rate limited.
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newBackend(ctx, &limited, *backend, jr, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	rep := newRunReport()
+	err = compressPDFs(ctx, s, b, jr, pdfs, opts, rep)
+	rep.finish()
+	rep.printSummary()
+	if *report != "" {
+		if err := rep.write(resolvePath(s, *report)); err != nil {
+			return fmt.Errorf("error writing report file: %v", err)
+		}
+	}
+	if ctx.Err() != nil {
+		t := rep.Totals
+		fmt.Printf("%d of %d pdfs were compressed, the originals of the rest were left untouched\nRun '%s -%s' to finish them\n",
+			t.Compressed+t.KeptOriginal+t.AlreadyCompressed, len(pdfs), compressCmd, resumeFlag)
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed := rep.Totals.Failed; failed > 0 {
+		return fmt.Errorf("%d of %d pdfs could not be compressed\nRun '%s -%s' to retry them", failed, len(pdfs), compressCmd, resumeFlag)
+	}
+	fmt.Println("All pdfs were compressed correctly")
+
 	return nil
 }
 
-// func callWithRetry[T any](s *state, iloveAPI *ilApi.Client, apiFunc func() (T, error)) (T, error) {
-// 	response, err := apiFunc()
-
-// 	if err != nil {
-// 		if isUnauthorized(err) {
-// 			err = checkToken(s, iloveAPI, s.cfg.GetKeyInfo().Token)
-// 			if err != nil {
-// 				return response, err
-// 			}
-
-// 			response, err = apiFunc()
-// 		}
-// 	}
-
-// 	return response, err
-// }
-
-// func isUnauthorized(err error) bool {
-// 	type unauthorized interface{ IsUnauthorized() bool }
-// 	var u unauthorized
-// 	return errors.As(err, &u) && u.IsUnauthorized()
-// }
-
-// func checkToken(s *state, iloveAPI *ilApi.Client, routineToken string) (err error) {
-// 	s.mu.Lock()
-// 	defer s.mu.Unlock()
-// 	currentKey := s.cfg.GetKeyInfo().Key
-// 	currentSavedToken := s.cfg.GetKeyInfo().Token
-
-// 	if routineToken == currentSavedToken {
-// 		fmt.Println("Refresing Token...")
-// 		newToken, err := iloveAPI.GenerateToken(currentKey)
-// 		if err != nil {
-// 			return err
-// 		}
-
-// 		err = s.cfg.SetToken(currentKey, newToken)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
-
-// 	return nil
-// }
-
-// func getConfigPdfsFile(cfgPDFsFile string) ([]PDFsConfig, error) {
-// 	configPdfsFile, err := os.Open(cfgPDFsFile)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	defer configPdfsFile.Close()
-
-// 	var cfg []PDFsConfig
-// 	if err := json.NewDecoder(configPdfsFile).Decode(&cfg); err != nil {
-// 		return nil, err
-// 	}
-
-// 	return cfg, nil
-// }
-
-func initConfig(s *state, cmd command) error {
+func getConfigPdfsFile(cfgPDFsFile string) ([]PDFsConfig, error) {
+	configPdfsFile, err := os.Open(cfgPDFsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer configPdfsFile.Close()
+
+	var cfg []PDFsConfig
+	if err := json.NewDecoder(configPdfsFile).Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	for i, pdf := range cfg {
+		if pdf.CompressionLevel == "" {
+			cfg[i].CompressionLevel = levelRecommended
+			continue
+		}
+		if !validCompressionLevel(pdf.CompressionLevel) {
+			return nil, fmt.Errorf("invalid compression_level %q for %s", pdf.CompressionLevel, pdf.Filename)
+		}
+	}
+
+	return cfg, nil
+}
+
+func validCompressionLevel(level string) bool {
+	return level == levelLow || level == levelRecommended || level == levelExtreme
+}
+
+type manifestOptions struct {
+	title  string
+	author string
+	level  string
+	// collisions is the strategy used to rename pdfs that would end up with
+	// the same new name.
+	collisions string
+	// discovery narrows down the pdfs added to the manifest.
+	discovery pdfs.Options
+}
+
+func initConfig(s *state, opts manifestOptions) error {
 	configFile := path.Join(s.wdir, configFile)
 	if _, err := os.Stat(configFile); !errors.Is(err, os.ErrNotExist) {
 		fmt.Println("The config pdfs file is already created")
 		fmt.Print("You want to delete it and create another one? (y/n) ")
 		var answer string
-		fmt.Scan(&answer)
+		fmt.Fscan(stdin, &answer)
 
 		lowAnswer := strings.ToLower(answer)
 		if lowAnswer != "y" && lowAnswer != "yes" {
@@ -238,10 +221,7 @@ func initConfig(s *state, cmd command) error {
 		}
 	}
 
-	title := cmd.Arguments[1]
-	author := cmd.Arguments[0]
-
-	err := generateConfigPdfsFile(s.wdir, configFile, title, author)
+	err := generateConfigPdfsFile(s.wdir, configFile, opts)
 	if err != nil {
 		return fmt.Errorf("error generating config pdfs file: %v", err)
 	}
@@ -250,49 +230,57 @@ func initConfig(s *state, cmd command) error {
 }
 
 type PDFsConfig struct {
-	Filename string `json:"filename"`
-	NewName  string `json:"new_name"`
-	Title    string `json:"title"`
-	Author   string `json:"author"`
+	Filename         string `json:"filename"`
+	NewName          string `json:"new_name"`
+	Title            string `json:"title"`
+	Author           string `json:"author"`
+	CompressionLevel string `json:"compression_level"`
+	// Order is the position of the pdf when the manifest is merged, pdfs
+	// without one are left out of it.
+	Order int `json:"order,omitempty"`
+	// Watermark is the stamp of the pdf when the manifest is watermarked.
+	Watermark *WatermarkConfig `json:"watermark,omitempty"`
+	// Password opens the pdf when it is encrypted and is the one protect
+	// sets otherwise.
+	Password string `json:"password,omitempty"`
 }
 
-func generateConfigPdfsFile(pdfDir, configPDFsFilePath, title, author string) error {
+func generateConfigPdfsFile(pdfDir, configPDFsFilePath string, opts manifestOptions) error {
 	cfgPDFsFile, err := os.Create(configPDFsFilePath)
 	if err != nil {
 		return err
 	}
 	defer cfgPDFsFile.Close()
 
-	pdfs, err := pdfs.GetFromDir(pdfDir)
+	pdfs, invalid, err := pdfs.GetFromDir(pdfDir, opts.discovery)
 	if err != nil {
 		return err
 	}
+	for _, path := range invalid {
+		fmt.Println(path, "--- Skipped, it has a .pdf extension but is not a pdf")
+	}
 
-	if len(pdfs) == 0 {
-		return fmt.Errorf("No pdfs found.")
+	c, err := cache.Open(filepath.Join(pdfDir, cacheFile))
+	if err != nil {
+		return err
 	}
 
 	pdfsInfo := []PDFsConfig{}
 	for _, pdf := range pdfs {
-		base := filepath.Base(pdf)
-		ext := filepath.Ext(pdf)
-		filenameWithoutExt := strings.TrimSuffix(base, ext)
-		newFilename := slug.Create(filenameWithoutExt) + pdfExt
-
-		var metaTitle string
-		if title == titleFilename {
-			metaTitle = filenameWithoutExt
-		} else {
-			metaTitle = title
+		hash, _, err := cache.HashFile(pdf.Path)
+		if err != nil {
+			return err
 		}
-
-		pdfsInfo = append(pdfsInfo, PDFsConfig{
-			Filename: pdf,
-			NewName:  newFilename,
-			Title:    metaTitle,
-			Author:   author,
-		})
+		if e, ok := c.Get(hash); ok {
+			fmt.Printf("%s --- Skipped, it was already compressed as %s\n", pdf.Path, e.NewName)
+			continue
+		}
+		pdfsInfo = append(pdfsInfo, newPDFConfig(pdf.Path, opts))
 	}
+	if len(pdfsInfo) == 0 {
+		return fmt.Errorf("No pdfs found.")
+	}
+	resolveCollisions(pdfsInfo, opts.collisions)
 
 	encoder := json.NewEncoder(cfgPDFsFile)
 	encoder.SetIndent("", "  ")
@@ -302,3 +290,45 @@ func generateConfigPdfsFile(pdfDir, configPDFsFilePath, title, author string) er
 
 	return nil
 }
+
+// filterPDFs leaves out of a manifest the pdfs that discovery wouldn't find
+// now, so the flags and ignore files also apply to manifests written before.
+func filterPDFs(s *state, manifest []PDFsConfig, opts pdfs.Options) ([]PDFsConfig, error) {
+	filter, err := pdfs.NewFilter(s.wdir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []PDFsConfig{}
+	for _, pdf := range manifest {
+		if filter.Match(resolvePath(s, pdf.Filename)) {
+			kept = append(kept, pdf)
+		}
+	}
+	return kept, nil
+}
+
+// newPDFConfig builds the manifest entry of a pdf: the new name is the slug
+// of the file name and the title is either opts.title or, when it is 'base',
+// the file name itself.
+func newPDFConfig(pdf string, opts manifestOptions) PDFsConfig {
+	base := filepath.Base(pdf)
+	ext := filepath.Ext(pdf)
+	filenameWithoutExt := strings.TrimSuffix(base, ext)
+	newFilename := slug.Create(filenameWithoutExt) + pdfExt
+
+	var metaTitle string
+	if opts.title == titleFilename {
+		metaTitle = filenameWithoutExt
+	} else {
+		metaTitle = opts.title
+	}
+
+	return PDFsConfig{
+		Filename:         pdf,
+		NewName:          newFilename,
+		Title:            metaTitle,
+		Author:           opts.author,
+		CompressionLevel: opts.level,
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func HandlerConfig(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+
+	var (
+		help = fs.Bool(initHelpFlag, false, "Show help message")
+		set  = fs.Bool("set", false, "Change the default of a flag -set <name> <value>")
+	)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	if *set {
+		cmd.Arguments = fs.Args()
+		if len(cmd.Arguments) != 2 {
+			fmt.Printf("Error: -set requires exactly two arguments: name and value.\nUsage: pressgo %s -set <name> <value>\n", configCmd)
+			os.Exit(1)
+		}
+
+		name, value := cmd.Arguments[0], cmd.Arguments[1]
+		if err := s.cfg.SetSetting(name, value); err != nil {
+			return err
+		}
+		fmt.Printf("The setting %v was set to %v\n", name, value)
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Setting", "Value"})
+	table.Bulk(s.cfg.GetSettings())
+	table.Render()
+
+	return nil
+}
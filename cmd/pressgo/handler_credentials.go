@@ -11,7 +11,7 @@ import (
 	"github.com/olekukonko/tablewriter"
 )
 
-func HandlerCredentials(s *state, cmd command) error {
+func HandlerCredentials(ctx context.Context, s *state, cmd command) error {
 	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
 
 	var (
@@ -19,6 +19,8 @@ func HandlerCredentials(s *state, cmd command) error {
 		add      = fs.Bool("add", false, "Add new credential -add <id> <key>")
 		delete   = fs.Bool("delete", false, "Delete credential -delete <id>")
 		activate = fs.Bool("activate", false, "Activate credential -activate <id>")
+		lock     = fs.Bool("lock", false, "Encrypt the stored credentials with a passphrase -lock\nThe passphrase is read from "+config.PassphraseEnv+" when it is set.")
+		unlock   = fs.Bool("unlock", false, "Store the credentials unencrypted again -unlock")
 	)
 	fs.Parse(cmd.Arguments)
 
@@ -34,7 +36,7 @@ func HandlerCredentials(s *state, cmd command) error {
 			os.Exit(1)
 		}
 
-		id, err := addCredential(s, cmd)
+		id, err := addCredential(ctx, s, cmd)
 		if err != nil {
 			return err
 		}
@@ -70,6 +72,41 @@ func HandlerCredentials(s *state, cmd command) error {
 		return nil
 	}
 
+	if *lock {
+		if s.cfg.IsLocked() {
+			fmt.Println("The credentials are already locked")
+			return nil
+		}
+
+		passphrase := os.Getenv(config.PassphraseEnv)
+		if passphrase == "" {
+			var err error
+			passphrase, err = promptNewPassphrase()
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := s.cfg.Lock(passphrase); err != nil {
+			return err
+		}
+		fmt.Println("The credentials were locked")
+		return nil
+	}
+
+	if *unlock {
+		if !s.cfg.IsLocked() {
+			fmt.Println("The credentials are not locked")
+			return nil
+		}
+
+		if err := s.cfg.Unlock(); err != nil {
+			return err
+		}
+		fmt.Println("The credentials were unlocked")
+		return nil
+	}
+
 	credentials := s.cfg.GetCredentials()
 	if len(credentials) == 0 {
 		fs.Usage()
@@ -84,11 +121,11 @@ func HandlerCredentials(s *state, cmd command) error {
 	return nil
 }
 
-func addCredential(s *state, cmd command) (string, error) {
+func addCredential(ctx context.Context, s *state, cmd command) (string, error) {
 	id := cmd.Arguments[0]
 	key := cmd.Arguments[1]
 
-	token, credits, err := validateCredential(s, key)
+	token, credits, err := validateCredential(ctx, s, key)
 	if err != nil {
 		return "", fmt.Errorf("Error in validating the credential")
 	}
@@ -101,14 +138,14 @@ func addCredential(s *state, cmd command) (string, error) {
 	return id, nil
 }
 
-func validateCredential(s *state, key string) (string, int, error) {
+func validateCredential(ctx context.Context, s *state, key string) (string, int, error) {
 	api := iloveapi.NewClient(s.client)
-	err := api.GenerateToken(context.Background(), key)
+	err := api.GenerateToken(ctx, key)
 	if err != nil {
 		return "", 0, err
 	}
 
-	start, err := api.Start(context.Background(), iloveapi.StartParams{Tool: toolCompress, Region: region})
+	start, err := api.Start(ctx, iloveapi.StartParams{Tool: toolCompress, Region: region})
 	if err != nil {
 		return "", 0, err
 	}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+var (
+	orientations = []string{"portrait", "landscape"}
+	pageSizes    = []string{"fit", "A4", "letter"}
+)
+
+type imagePDFOptions struct {
+	orientation string
+	// margin is the space around the image, in pixels.
+	margin   int
+	pageSize string
+	// merge writes every image, in order, as a page of a single pdf whose
+	// name is the slug of name.
+	merge     bool
+	name      string
+	out       string
+	overwrite bool
+}
+
+func (o imagePDFOptions) toolOptions() map[string]any {
+	return map[string]any{
+		"orientation": o.orientation,
+		"margin":      o.margin,
+		"pagesize":    o.pageSize,
+		"merge_after": o.merge,
+	}
+}
+
+func HandlerImagePDF(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help        = fs.Bool(initHelpFlag, false, "Show help message")
+		orientation = fs.String(orientFlag, "portrait", "Orientation of the pages -orientation <portrait|landscape>")
+		margin      = fs.Int(marginFlag, 0, "Space around each image, in pixels -margin <n>")
+		pageSize    = fs.String(pageSizeFlag, "fit", "Size of the pages -page-size <fit|A4|letter>\nfit makes each page the size of its image.")
+		merge       = fs.Bool(mergeFlag, false, "Write all the images, in order, into a single pdf -merge")
+		name        = fs.String(nameFlag, imagesName, "Name of the pdf written by -merge, slugified like the compressed ones -name <name>")
+		out         = fs.String(outFlag, "", "Directory the pdfs are written to -out <dir>\nDefaults to the current directory.")
+		overwrite   = fs.Bool(overwriteFlag, false, "Replace files that already have the name of a pdf -overwrite")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	discovery := addDiscoveryFlags(fs)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	if !slices.Contains(orientations, *orientation) {
+		return fmt.Errorf("invalid orientation %q, use portrait or landscape", *orientation)
+	}
+	if !slices.Contains(pageSizes, *pageSize) {
+		return fmt.Errorf("invalid page size %q, use fit, A4 or letter", *pageSize)
+	}
+	if *margin < 0 {
+		return fmt.Errorf("-%s can't be negative", marginFlag)
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+	discoveryOpts, err := discovery.options()
+	if err != nil {
+		return err
+	}
+
+	// Without files, the images of the working directory are converted.
+	images, err := discoverInputs(s, fs.Args(), discoveryOpts, true)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if _, ok, err := pdfs.SniffImage(resolvePath(s, image.Filename)); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%s is not a jpg or png image", image.Filename)
+		}
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	opts := imagePDFOptions{
+		orientation: *orientation,
+		margin:      *margin,
+		pageSize:    *pageSize,
+		merge:       *merge,
+		name:        *name,
+		out:         *out,
+		overwrite:   *overwrite,
+	}
+	if opts.merge {
+		files := make([]string, len(images))
+		for i, image := range images {
+			files[i] = image.Filename
+		}
+		return imagesToPDF(ctx, s, b, files, newPDFConfig(opts.name+pdfExt, manifestOptions{}), opts)
+	}
+
+	failed := 0
+	for _, image := range images {
+		if err := imagesToPDF(ctx, s, b, []string{image.Filename}, image, opts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println(image.Filename, "--- Error:", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images could not be converted", failed, len(images))
+	}
+	return nil
+}
+
+// imagesToPDF converts the images into a pdf with one page per image, written
+// under the new name of pdf.
+func imagesToPDF(ctx context.Context, s *state, b *ilovePDFBackend, images []string, pdf PDFsConfig, opts imagePDFOptions) error {
+	paths := make([]string, len(images))
+	for i, image := range images {
+		paths[i] = resolvePath(s, image)
+	}
+
+	output := outputPath(s, pdf, compressOptions{out: opts.out})
+	if _, err := os.Stat(output); err == nil && !opts.overwrite {
+		return fmt.Errorf("%s already exists, use -%s to replace it", output, overwriteFlag)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+
+	part := output + partExt
+	defer os.Remove(part)
+
+	fmt.Printf("Converting %d images into %s\n", len(images), pdf.NewName)
+	u, err := b.runTool(ctx, toolTask{tool: toolImagePDF, files: paths, options: opts.toolOptions()}, part)
+	if err != nil {
+		return err
+	}
+	if err := verifyPages(part, len(images)); err != nil {
+		return err
+	}
+	if err := os.Rename(part, output); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s --- Written using %d credits\n", output, u.credits)
+	return nil
+}
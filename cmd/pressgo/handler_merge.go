@@ -0,0 +1,236 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+	"github.com/fernando8franco/pressgo/pkg/pdfdoc"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+type mergeOptions struct {
+	// name is slugified into the name of the merged pdf.
+	name      string
+	manifest  manifestOptions
+	out       string
+	overwrite bool
+	// compress also compresses the merged pdf, keeping whichever is smaller.
+	compress bool
+	api      apiOptions
+}
+
+func HandlerMerge(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help      = fs.Bool(initHelpFlag, false, "Show help message")
+		name      = fs.String(nameFlag, mergeName, "Name of the merged pdf, slugified like the compressed ones -name <name>")
+		title     = fs.String(titleFlag, titleFilename, "Title of the merged pdf -title <title>\nIf title == 'base', the title is the name.")
+		author    = fs.String(authorFlag, "", "Author of the merged pdf -author <author>")
+		compress  = fs.Bool(compressFlag, false, "Compress the merged pdf in the same run -compress")
+		level     = fs.String(levelFlag, levelRecommended, "Compression level used with -compress -level <low|recommended|extreme>")
+		out       = fs.String(outFlag, "", "Directory the merged pdf is written to -out <dir>\nDefaults to the current directory.")
+		overwrite = fs.Bool(overwriteFlag, false, "Replace a file that already has the name of the merged pdf -overwrite")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	if !validCompressionLevel(*level) {
+		return fmt.Errorf("invalid compression level %q, use %s, %s or %s", *level, levelLow, levelRecommended, levelExtreme)
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		configFile := filepath.Join(s.wdir, configFile)
+		if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Error: merge requires the pdfs to merge, in order, or a config file with an order for them.\nUsage: pressgo %s [flags] <pdf> <pdf>...\n", mergeCmd)
+			os.Exit(1)
+		}
+
+		manifest, err := getConfigPdfsFile(configFile)
+		if err != nil {
+			return err
+		}
+		files = orderedPDFs(manifest)
+	}
+
+	return mergePDFs(ctx, s, files, mergeOptions{
+		name:      *name,
+		manifest:  manifestOptions{title: *title, author: *author, level: *level},
+		out:       *out,
+		overwrite: *overwrite,
+		compress:  *compress,
+		api:       apiOpts,
+	})
+}
+
+// orderedPDFs returns the files of the manifest that have an order, sorted by
+// it.
+func orderedPDFs(manifest []PDFsConfig) []string {
+	ordered := slices.DeleteFunc(slices.Clone(manifest), func(pdf PDFsConfig) bool {
+		return pdf.Order <= 0
+	})
+	slices.SortStableFunc(ordered, func(a, b PDFsConfig) int {
+		return cmp.Compare(a.Order, b.Order)
+	})
+
+	files := []string{}
+	for _, pdf := range ordered {
+		files = append(files, pdf.Filename)
+	}
+	return files
+}
+
+// mergePDFs merges files, in order, into one pdf named and titled like a
+// compressed one. The originals are left as they are.
+func mergePDFs(ctx context.Context, s *state, files []string, opts mergeOptions) error {
+	if len(files) < 2 {
+		return fmt.Errorf("at least two pdfs are needed to merge, got %d", len(files))
+	}
+
+	// The merged pdf has the pages of all of them, unless one can't be read.
+	pages := 0
+	for i, file := range files {
+		files[i] = resolvePath(s, file)
+		n, err := pdfPages(files[i])
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if n < 0 || pages < 0 {
+			pages = -1
+			continue
+		}
+		pages += n
+	}
+
+	pdf := newPDFConfig(opts.name+pdfExt, opts.manifest)
+	dir := s.wdir
+	if opts.out != "" {
+		dir = resolvePath(s, opts.out)
+	}
+	output := filepath.Join(dir, pdf.NewName)
+	if _, err := os.Stat(output); err == nil && !opts.overwrite {
+		return fmt.Errorf("%s already exists, use -%s to replace it", output, overwriteFlag)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, opts.api.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, opts.api.retry)
+	if err != nil {
+		return err
+	}
+
+	meta := iloveapi.Meta{Title: pdf.Title, Author: pdf.Author}
+	part := output + partExt
+	defer os.Remove(part)
+
+	fmt.Printf("Merging %d pdfs into %s\n", len(files), pdf.NewName)
+	u, err := b.runTool(ctx, toolTask{tool: toolMerge, files: files, meta: meta}, part)
+	if err != nil {
+		return err
+	}
+	if err := verifyPages(part, pages); err != nil {
+		return err
+	}
+	credits := u.credits
+
+	if opts.compress {
+		compressed := output + ".compressed" + partExt
+		defer os.Remove(compressed)
+
+		fmt.Println("Compressing:", pdf.NewName)
+		u, err := b.runTool(ctx, toolTask{
+			tool:    toolCompress,
+			files:   []string{part},
+			names:   map[int]string{0: filepath.Base(output)},
+			meta:    meta,
+			options: map[string]any{"compression_level": pdf.CompressionLevel},
+		}, compressed)
+		credits += u.credits
+		if err != nil {
+			return err
+		}
+		if err := verifyPages(compressed, pages); err != nil {
+			return err
+		}
+
+		if smaller(compressed, part) {
+			if err := os.Rename(compressed, part); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println(pdf.NewName, "--- Kept uncompressed, the compressed file was not smaller")
+		}
+	}
+
+	if err := os.Rename(part, output); err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d pdfs into %s using %d credits\n", len(files), output, credits)
+	return nil
+}
+
+// pdfPages returns the pages of the pdf at path, or -1 when pdfdoc can't
+// read it. Files that aren't pdfs are an error.
+func pdfPages(path string) (int, error) {
+	_, ok, err := pdfs.Sniff(path)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("the file is not a pdf")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := pdfdoc.Verify(data)
+	if err != nil {
+		return -1, nil
+	}
+	return n, nil
+}
+
+// verifyPages checks that the output at path is a complete pdf with the
+// given pages, when they are known.
+func verifyPages(path string, pages int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := pdfdoc.Verify(data)
+	if err != nil {
+		return fmt.Errorf("the output is not a valid pdf: %v", err)
+	}
+	if pages >= 0 && n != pages {
+		return fmt.Errorf("the output has %d pages, want %d", n, pages)
+	}
+	return nil
+}
+
+func smaller(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && infoA.Size() < infoB.Size()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+const (
+	// jpgPages renders every page as a jpg and jpgExtract saves the images
+	// embedded in the pdf.
+	jpgPages   = "pages"
+	jpgExtract = "extract"
+)
+
+type pdfJPGOptions struct {
+	mode      string
+	out       string
+	overwrite bool
+}
+
+func HandlerPDFJPG(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help      = fs.Bool(initHelpFlag, false, "Show help message")
+		mode      = fs.String(modeFlag, jpgPages, "What is converted -mode <pages|extract>\npages renders every page and extract saves the images inside the pdf.")
+		out       = fs.String(outFlag, "", "Directory the folders of images are written to -out <dir>\nDefaults to the current directory.")
+		overwrite = fs.Bool(overwriteFlag, false, "Write into folders that already have files -overwrite")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	discovery := addDiscoveryFlags(fs)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	if *mode != jpgPages && *mode != jpgExtract {
+		return fmt.Errorf("invalid mode %q, use %s or %s", *mode, jpgPages, jpgExtract)
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+	discoveryOpts, err := discovery.options()
+	if err != nil {
+		return err
+	}
+
+	// Without files, the pdfs of the working directory are converted.
+	inputs, err := discoverInputs(s, fs.Args(), discoveryOpts, false)
+	if err != nil {
+		return err
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	opts := pdfJPGOptions{mode: *mode, out: *out, overwrite: *overwrite}
+	failed := 0
+	for _, pdf := range inputs {
+		if err := pdfToJPG(ctx, s, b, pdf, opts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println(pdf.Filename, "--- Error:", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pdfs could not be converted", failed, len(inputs))
+	}
+	return nil
+}
+
+// pdfToJPG converts a pdf into jpgs written to a folder named after the slug
+// of the pdf, like invoices/invoices-1.jpg. Encrypted pdfs are opened with
+// their password.
+func pdfToJPG(ctx context.Context, s *state, b *ilovePDFBackend, pdf PDFsConfig, opts pdfJPGOptions) error {
+	path := resolvePath(s, pdf.Filename)
+	if _, err := pdfPages(path); err != nil {
+		return err
+	}
+
+	var passwords map[int]string
+	encrypted, err := pdfs.Encrypted(path)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		password := pdfPassword(pdf)
+		if password == "" {
+			return fmt.Errorf("the file is encrypted, set its password in %s", passwordEnv)
+		}
+		passwords = map[int]string{0: password}
+	}
+
+	base := strings.TrimSuffix(pdf.NewName, pdfExt)
+	dir := filepath.Dir(outputPath(s, pdf, compressOptions{out: opts.out}))
+	folder := filepath.Join(dir, base)
+	if entries, err := os.ReadDir(folder); err == nil && len(entries) > 0 && !opts.overwrite {
+		return fmt.Errorf("%s already has files, use -%s to write into it", folder, overwriteFlag)
+	}
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+
+	download := filepath.Join(dir, base+".jpg"+partExt)
+	defer os.Remove(download)
+
+	fmt.Println("Converting:", pdf.Filename)
+	u, err := b.runTool(ctx, toolTask{
+		tool:      toolPDFJPG,
+		files:     []string{path},
+		options:   map[string]any{"pdfjpg_mode": opts.mode},
+		passwords: passwords,
+	}, download)
+	if err != nil {
+		return err
+	}
+
+	images, err := unpackImages(download, folder, base)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s --- Written %d images to %s using %d credits\n", pdf.Filename, images, folder, u.credits)
+	return nil
+}
+
+// unpackImages writes the jpgs of the download of a task to folder as
+// base-1.jpg, base-2.jpg... A zip has one jpg per file, anything else is a
+// single jpg. It returns how many were written.
+func unpackImages(download, folder, base string) (int, error) {
+	zipped, err := isZip(download)
+	if err != nil {
+		return 0, err
+	}
+
+	var written []string
+	add := func(n int, r io.Reader) error {
+		path := filepath.Join(folder, fmt.Sprintf("%s-%d%s", base, n, jpgExt))
+		if err := writeFile(path, r); err != nil {
+			return err
+		}
+		written = append(written, path)
+
+		format, ok, err := pdfs.SniffImage(path)
+		if err != nil {
+			return err
+		}
+		if !ok || format != pdfs.JPEG {
+			return errors.New("the output is not a jpg")
+		}
+		return nil
+	}
+
+	if zipped {
+		var numbers map[string]int
+		if numbers, err = imageNumbers(download); err == nil {
+			err = unzipEach(download, func(name string, r io.Reader) error { return add(numbers[name], r) })
+		}
+	} else {
+		var file *os.File
+		if file, err = os.Open(download); err == nil {
+			err = add(1, file)
+			file.Close()
+		}
+	}
+	if err == nil && len(written) == 0 {
+		err = errors.New("the output has no images")
+	}
+	if err != nil {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		return 0, err
+	}
+	return len(written), nil
+}
+
+// imageDigits matches the numbers in the name of an image of a zip, like the
+// page in survey-0003.jpg.
+var imageDigits = regexp.MustCompile(`\d+`)
+
+// imageNumbers numbers the images of the zip download by the numbers in their
+// names rather than the order they are stored, so with one image per page
+// base-3.jpg is the third page.
+func imageNumbers(download string) (map[string]int, error) {
+	names, err := zipNames(download)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]int, len(names))
+	for _, name := range names {
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("the output has two images named %s", name)
+		}
+		var key []int
+		for _, digits := range imageDigits.FindAllString(path.Base(name), -1) {
+			n, err := strconv.Atoi(digits)
+			if err != nil {
+				return nil, fmt.Errorf("the output has an image with an unexpected name: %s", name)
+			}
+			key = append(key, n)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("the output has an image with an unexpected name: %s", name)
+		}
+		keys[name] = key
+	}
+
+	slices.SortFunc(names, func(a, b string) int { return slices.Compare(keys[a], keys[b]) })
+	numbers := make(map[string]int, len(names))
+	for i, name := range names {
+		if i > 0 && slices.Equal(keys[name], keys[names[i-1]]) {
+			return nil, fmt.Errorf("the output has two images numbered like %s", name)
+		}
+		numbers[name] = i + 1
+	}
+	return numbers, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+// passwordOptions are the options shared by the protect and unlock commands.
+type passwordOptions struct {
+	// password is used for every pdf, before the one of the manifest and
+	// the one of the environment.
+	password  string
+	out       string
+	overwrite bool
+}
+
+func HandlerProtect(ctx context.Context, s *state, cmd command) error {
+	return handlePassword(ctx, s, cmd, toolProtect)
+}
+
+func HandlerUnlock(ctx context.Context, s *state, cmd command) error {
+	return handlePassword(ctx, s, cmd, toolUnlock)
+}
+
+// handlePassword runs the protect or unlock tool over the pdfs given or the
+// ones of the manifest. Pdfs that are already as the tool would leave them
+// are skipped without spending credits.
+func handlePassword(ctx context.Context, s *state, cmd command, tool string) error {
+	verb := passwordVerb(tool)
+
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help      = fs.Bool(initHelpFlag, false, "Show help message")
+		password  = fs.String(passwordFlag, "", fmt.Sprintf("Password of the pdfs -password <password>\nDefaults to the password of each pdf in the config file, then to %s.", passwordEnv))
+		out       = fs.String(outFlag, "", fmt.Sprintf("Directory the %s pdfs are written to -out <dir>\nDefaults to the current directory.", verb))
+		overwrite = fs.Bool(overwriteFlag, false, fmt.Sprintf("Replace files that already have the name of a %s pdf -overwrite", verb))
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	manifest, ok, err := toolPDFs(s, fs.Args())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("Error: %s requires the pdfs to use or a config file.\nUsage: pressgo %s -%s <password> <pdf>...\n", cmd.Name, cmd.Name, passwordFlag)
+		os.Exit(1)
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	opts := passwordOptions{password: *password, out: *out, overwrite: *overwrite}
+	failed, done := 0, 0
+	for _, pdf := range manifest {
+		if err := passwordPDF(ctx, s, b, tool, pdf, opts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println(pdf.Filename, "--- Error:", err)
+			failed++
+			continue
+		}
+		done++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pdfs could not be %s", failed, failed+done, verb)
+	}
+	return nil
+}
+
+// passwordPDF protects or unlocks a pdf and writes it under its new name.
+func passwordPDF(ctx context.Context, s *state, b *ilovePDFBackend, tool string, pdf PDFsConfig, opts passwordOptions) error {
+	path := resolvePath(s, pdf.Filename)
+	encrypted, err := pdfs.Encrypted(path)
+	if err != nil {
+		return err
+	}
+	if tool == toolProtect && encrypted {
+		fmt.Println(pdf.Filename, "--- Skipped, it is already encrypted")
+		return nil
+	}
+	if tool == toolUnlock && !encrypted {
+		fmt.Println(pdf.Filename, "--- Skipped, it is not encrypted")
+		return nil
+	}
+
+	password := opts.password
+	if password == "" {
+		password = pdfPassword(pdf)
+	}
+	if password == "" {
+		return fmt.Errorf("it has no password, use -%s, the config file or %s", passwordFlag, passwordEnv)
+	}
+
+	output := outputPath(s, pdf, compressOptions{out: opts.out})
+	// A bad password or round-trip must leave the original to start over
+	// from, so it is never written over it.
+	if sameFile(path, output) {
+		return fmt.Errorf("it would be %s over itself, use -%s or a different new_name", passwordVerb(tool), outFlag)
+	}
+	if !opts.overwrite && overwrites(path, output) {
+		return fmt.Errorf("%s already exists, use -%s to replace it", output, overwriteFlag)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+
+	part := output + partExt
+	defer os.Remove(part)
+
+	var u usage
+	if tool == toolProtect {
+		fmt.Println("Protecting:", pdf.Filename)
+		u, err = b.protect(ctx, path, password, part)
+	} else {
+		fmt.Println("Unlocking:", pdf.Filename)
+		u, err = b.unlock(ctx, path, password, part)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(part, output); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s --- Written: %s using %d credits\n", pdf.Filename, output, u.credits)
+	return nil
+}
+
+// passwordVerb is the past tense of what tool does to a pdf.
+func passwordVerb(tool string) string {
+	if tool == toolUnlock {
+		return "unlocked"
+	}
+	return "protected"
+}
+
+// pdfPassword is the password of a pdf: the one in the manifest or, without
+// it, the one of the environment.
+func pdfPassword(pdf PDFsConfig) string {
+	if pdf.Password != "" {
+		return pdf.Password
+	}
+	return os.Getenv(passwordEnv)
+}
+
+// protect writes to dst a copy of src that opens with password.
+func (b *ilovePDFBackend) protect(ctx context.Context, src, password, dst string) (usage, error) {
+	u, err := b.runTool(ctx, toolTask{
+		tool:    toolProtect,
+		files:   []string{src},
+		options: map[string]any{"password": password},
+	}, dst)
+	if err != nil {
+		return u, err
+	}
+
+	if encrypted, err := pdfs.Encrypted(dst); err != nil || !encrypted {
+		return u, errors.New("the protected file is not encrypted")
+	}
+	return u, nil
+}
+
+// unlock writes to dst a copy of src without its password.
+func (b *ilovePDFBackend) unlock(ctx context.Context, src, password, dst string) (usage, error) {
+	u, err := b.runTool(ctx, toolTask{
+		tool:      toolUnlock,
+		files:     []string{src},
+		passwords: map[int]string{0: password},
+	}, dst)
+	if err != nil {
+		return u, err
+	}
+
+	if _, ok, err := pdfs.Sniff(dst); err != nil || !ok {
+		return u, errors.New("the unlocked file is not a pdf")
+	}
+	if encrypted, err := pdfs.Encrypted(dst); err != nil || encrypted {
+		return u, errors.New("the unlocked file is still encrypted")
+	}
+	return u, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/pkg/slug"
+)
+
+type splitOptions struct {
+	// Only one of ranges, every and extract is set.
+	ranges    string
+	every     int
+	extract   string
+	out       string
+	overwrite bool
+	api       apiOptions
+}
+
+func HandlerSplit(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help      = fs.Bool(initHelpFlag, false, "Show help message")
+		ranges    = fs.String(rangesFlag, "", "Split in one pdf per range -ranges <1-3,7,10->\nAn open range runs to the last page.")
+		every     = fs.Int(everyFlag, 0, "Split in pdfs of n pages -every <n>")
+		extract   = fs.String(extractFlag, "", "Extract the pages into a single pdf -extract <2,5-7>")
+		out       = fs.String(outFlag, "", "Directory the pdfs are written to -out <dir>\nDefaults to the current directory.")
+		overwrite = fs.Bool(overwriteFlag, false, "Replace files that already have the name of an output -overwrite")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		fmt.Printf("Error: split requires the pdfs to split.\nUsage: pressgo %s -%s <ranges>|-%s <n>|-%s <pages> <pdf>...\n", splitCmd, rangesFlag, everyFlag, extractFlag)
+		os.Exit(1)
+	}
+
+	modes := 0
+	for _, set := range []bool{*ranges != "", *every != 0, *extract != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		return fmt.Errorf("use exactly one of -%s, -%s and -%s", rangesFlag, everyFlag, extractFlag)
+	}
+	if *every < 0 {
+		return fmt.Errorf("-%s must be greater than 0", everyFlag)
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	opts := splitOptions{ranges: *ranges, every: *every, extract: *extract, out: *out, overwrite: *overwrite, api: apiOpts}
+	failed := 0
+	for _, file := range files {
+		if err := splitPDF(ctx, s, b, file, opts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println(file, "--- Error:", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pdfs could not be split", failed, len(files))
+	}
+	return nil
+}
+
+// splitOutput is a pdf a split writes and the pages it should have.
+type splitOutput struct {
+	path  string
+	pages int
+	// rangeName is the range of the output, like 1-3 or 7, the suffix of the
+	// name the API gives it.
+	rangeName string
+}
+
+// rangeSuffix matches the range at the end of the name of a split output,
+// like invoices-1-3.pdf or invoices-7.pdf.
+var rangeSuffix = regexp.MustCompile(`(?:^|[-_ ])(\d+)(?:-(\d+))?\.(?i:pdf)$`)
+
+// splitPDF splits a pdf with the split tool. The outputs are named after the
+// slug of the pdf and their pages, like invoices-1-3.pdf.
+func splitPDF(ctx context.Context, s *state, b *ilovePDFBackend, file string, opts splitOptions) error {
+	path := resolvePath(s, file)
+	pages, err := pdfPages(path)
+	if err != nil {
+		return err
+	}
+	if pages < 0 {
+		return fmt.Errorf("the pages of the pdf can't be counted")
+	}
+
+	var ranges []pageRange
+	var options map[string]any
+	switch {
+	case opts.every > 0:
+		ranges = everyRanges(opts.every, pages)
+		options = map[string]any{"split_mode": "fixed_range", "fixed_range": opts.every}
+	case opts.ranges != "":
+		if ranges, err = parseRanges(opts.ranges, pages); err != nil {
+			return err
+		}
+		options = map[string]any{"split_mode": "ranges", "ranges": joinRanges(ranges, ",")}
+	default:
+		if ranges, err = parseRanges(opts.extract, pages); err != nil {
+			return err
+		}
+		options = map[string]any{"split_mode": "ranges", "ranges": joinRanges(ranges, ","), "merge_after": true}
+	}
+
+	dir := s.wdir
+	if opts.out != "" {
+		dir = resolvePath(s, opts.out)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	base := slug.Create(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	var outputs []splitOutput
+	if opts.extract != "" {
+		total := 0
+		for _, r := range ranges {
+			total += r.pages()
+		}
+		outputs = append(outputs, splitOutput{path: filepath.Join(dir, base+"-"+joinRanges(ranges, "_")+pdfExt), pages: total})
+	} else {
+		for _, r := range ranges {
+			outputs = append(outputs, splitOutput{path: filepath.Join(dir, base+"-"+r.String()+pdfExt), pages: r.pages(), rangeName: r.String()})
+		}
+	}
+	for _, o := range outputs {
+		if _, err := os.Stat(o.path); err == nil && !opts.overwrite {
+			return fmt.Errorf("%s already exists, use -%s to replace it", o.path, overwriteFlag)
+		}
+	}
+
+	download := filepath.Join(dir, base+".split"+partExt)
+	defer os.Remove(download)
+
+	fmt.Println("Splitting:", file)
+	u, err := b.runTool(ctx, toolTask{tool: toolSplit, files: []string{path}, options: options}, download)
+	if err != nil {
+		return err
+	}
+
+	if err := unpackOutputs(download, outputs); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s --- Split into %d pdfs using %d credits\n", file, len(outputs), u.credits)
+	return nil
+}
+
+// unpackOutputs moves the download of a task to its outputs: a zip has one
+// file per output, told apart by the range their names end with, anything
+// else is the only output. The outputs are only replaced once all of them
+// are unpacked and verified, so a bad download leaves the files of an earlier
+// run as they were.
+func unpackOutputs(download string, outputs []splitOutput) error {
+	zipped, err := isZip(download)
+	if err != nil {
+		return err
+	}
+
+	if !zipped {
+		if len(outputs) != 1 {
+			return fmt.Errorf("got 1 pdf, want %d", len(outputs))
+		}
+		if err := verifyPages(download, outputs[0].pages); err != nil {
+			return err
+		}
+		return os.Rename(download, outputs[0].path)
+	}
+
+	byRange := map[string]splitOutput{}
+	for _, o := range outputs {
+		byRange[o.rangeName] = o
+	}
+
+	n := 0
+	parts := map[string]string{}
+	err = unzipEach(download, func(name string, r io.Reader) error {
+		n++
+		o, ok := outputForEntry(name, byRange)
+		if !ok {
+			return fmt.Errorf("the output %q doesn't match any range", name)
+		}
+		if _, ok := parts[o.path]; ok {
+			return fmt.Errorf("the output %q repeats the range %s", name, o.rangeName)
+		}
+		part := o.path + partExt
+		parts[o.path] = part
+
+		if err := writeFile(part, r); err != nil {
+			return err
+		}
+		return verifyPages(part, o.pages)
+	})
+	if err == nil && (n != len(outputs) || len(parts) != len(outputs)) {
+		err = fmt.Errorf("got %d pdfs, want %d", n, len(outputs))
+	}
+	if err != nil {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+		return err
+	}
+
+	for _, o := range outputs {
+		if err := os.Rename(parts[o.path], o.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputForEntry finds the output of a zip entry by the range its name ends
+// with. A name like report-7-8.pdf may also be page 8 of report-7.pdf.
+func outputForEntry(name string, byRange map[string]splitOutput) (splitOutput, bool) {
+	if len(byRange) == 1 {
+		for _, o := range byRange {
+			return o, true
+		}
+	}
+
+	m := rangeSuffix.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return splitOutput{}, false
+	}
+	if m[2] != "" {
+		if o, ok := byRange[m[1]+"-"+m[2]]; ok {
+			return o, true
+		}
+		o, ok := byRange[m[2]]
+		return o, ok
+	}
+	o, ok := byRange[m[1]]
+	return o, ok
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fernando8franco/pressgo/internal/config"
+	"github.com/fernando8franco/pressgo/internal/journal"
+	"github.com/fernando8franco/pressgo/internal/watch"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+	"golang.org/x/sync/errgroup"
+)
+
+type watchOptions struct {
+	manifest manifestOptions
+	out      string
+	backend  string
+	interval time.Duration
+	poll     bool
+	compress compressOptions
+	api      apiOptions
+}
+
+func HandlerWatch(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help     = fs.Bool(initHelpFlag, false, "Show help message")
+		out      = fs.String(outFlag, "", "Directory the compressed pdfs are moved to -out <dir>\nDefaults to a '"+watchOutDir+"' directory inside the watched one.")
+		title    = fs.String(titleFlag, titleFilename, "Title of the compressed pdfs -title <title>\nIf title == 'base', the title is the file name.")
+		author   = fs.String(authorFlag, "", "Author of the compressed pdfs -author <author>")
+		level    = fs.String(levelFlag, levelRecommended, "Compression level -level <low|recommended|extreme>")
+		backend  = fs.String(backendFlag, backendILovePDF, "Compression backend -backend <ilovepdf|local>")
+		interval = fs.Duration(intervalFlag, watch.DefaultInterval, "How long a file must stop growing before it is compressed -interval <duration>\nIt is also how often the directory is scanned when polling.")
+		poll     = fs.Bool(pollFlag, false, "Scan the directory instead of using file system notifications -poll")
+		smaller  = fs.Bool(replaceIfSmallerFlag, false, "Keep the original, renamed, when the compressed file isn't smaller -replace-if-smaller")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	discovery := addDiscoveryFlags(fs)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	cmd.Arguments = fs.Args()
+	if len(cmd.Arguments) != 1 {
+		fmt.Printf("Error: watch requires exactly one argument: the directory to watch.\nUsage: pressgo %s <dir>\n", watchCmd)
+		os.Exit(1)
+	}
+
+	if !validCompressionLevel(*level) {
+		return fmt.Errorf("invalid compression level %q, use %s, %s or %s", *level, levelLow, levelRecommended, levelExtreme)
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	discoveryOpts, err := discovery.options()
+	if err != nil {
+		return err
+	}
+
+	return watchPDFs(ctx, s, resolvePath(s, cmd.Arguments[0]), watchOptions{
+		manifest: manifestOptions{title: *title, author: *author, level: *level, discovery: discoveryOpts},
+		out:      *out,
+		backend:  *backend,
+		interval: *interval,
+		poll:     *poll,
+		compress: compressOptions{replaceIfSmaller: *smaller, workers: apiOpts.workers},
+		api:      apiOpts,
+	})
+}
+
+// watchPDFs compresses the pdfs that appear under dir until ctx is done. The
+// outputs are written to the target directory and the originals removed, so
+// each file is compressed once.
+func watchPDFs(ctx context.Context, s *state, dir string, opts watchOptions) error {
+	out := opts.out
+	if out == "" {
+		out = filepath.Join(dir, watchOutDir)
+	}
+	out = resolvePath(s, out)
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+
+	// Outputs are named relative to the target directory.
+	ws := *s
+	ws.wdir = out
+	ws.client = withRateLimit(s.client, opts.api.rateLimit)
+
+	jr, err := journal.Open(filepath.Join(out, journalFile))
+	if err != nil {
+		return fmt.Errorf("error reading journal file: %v", err)
+	}
+
+	b, err := newBackend(ctx, &ws, opts.backend, jr, opts.api.retry)
+	if err != nil {
+		return err
+	}
+
+	filter, err := pdfs.NewFilter(dir, opts.manifest.discovery)
+	if err != nil {
+		return err
+	}
+
+	// A worker that fails, like when the credits run out, also stops the
+	// watch so the loop over its events ends.
+	wg, ctx := errgroup.WithContext(ctx)
+	events, err := watch.Watch(ctx, dir, watch.Options{Interval: opts.interval, Poll: opts.poll, Exclude: out})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Watching %s, compressed pdfs go to %s\n", dir, out)
+
+	names := &watchNames{out: out, jr: jr, claimed: map[string]string{}}
+	var (
+		mu         sync.Mutex
+		inFlight   = map[string]bool{}
+		waiting    sync.WaitGroup
+		compressed atomic.Int32
+	)
+	done := func(path string) {
+		mu.Lock()
+		delete(inFlight, path)
+		mu.Unlock()
+	}
+
+	pdfsChannel := make(chan string)
+
+	for range max(1, opts.compress.workers) {
+		wg.Go(func() error {
+			for path := range pdfsChannel {
+				err := watchCompress(ctx, &ws, b, jr, names, path, opts)
+				done(path)
+				if errors.Is(err, config.ErrNoCredits) {
+					return err
+				}
+				if err == nil {
+					compressed.Add(1)
+				}
+			}
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		defer func() {
+			waiting.Wait()
+			close(pdfsChannel)
+		}()
+
+		for path := range events {
+			ext := filepath.Ext(path)
+			if !strings.EqualFold(ext, pdfExt) && (ext != "" || !opts.manifest.discovery.Extensionless) || !filter.Match(path) {
+				continue
+			}
+
+			mu.Lock()
+			busy := inFlight[path]
+			inFlight[path] = true
+			mu.Unlock()
+			if busy {
+				continue
+			}
+
+			// Each file waits on its own so a slow scan doesn't hold up the rest.
+			waiting.Go(func() {
+				if err := watch.WaitStable(ctx, path, opts.interval); err != nil {
+					done(path)
+					return
+				}
+				// Only a complete file can be told apart by its content.
+				if _, ok, err := pdfs.Sniff(path); err != nil || !ok {
+					if err == nil && ext != "" {
+						fmt.Println(path, "--- Skipped, it has a .pdf extension but is not a pdf")
+					}
+					done(path)
+					return
+				}
+				if info, err := os.Stat(path); err != nil || info.Size() < opts.manifest.discovery.MinSize {
+					done(path)
+					return
+				}
+
+				select {
+				case pdfsChannel <- path:
+				case <-ctx.Done():
+				}
+			})
+		}
+		return nil
+	})
+
+	err = wg.Wait()
+	fmt.Printf("Stopped watching %s, %d pdfs were compressed\n", dir, compressed.Load())
+	return err
+}
+
+// watchNames hands out the new names of the pdfs of a watch. Files whose
+// names slug to the same one get -2, -3... like the suffix collision strategy,
+// so an output never replaces the output of another file.
+type watchNames struct {
+	mu  sync.Mutex
+	out string
+	jr  *journal.Journal
+	// claimed are the new names handed out, lowercased, and the file each
+	// one is for.
+	claimed map[string]string
+}
+
+// claim returns pdf with a new name no other file uses. The name it got
+// before, if any, is kept. It also reports whether the name is recorded as
+// the output of pdf, by an earlier scan of the same file.
+func (n *watchNames) claim(pdf PDFsConfig) (PDFsConfig, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var candidates []string
+	if previous := n.jr.Get(pdf.Filename, "").NewName; previous != "" {
+		candidates = append(candidates, previous)
+	}
+	candidates = append(candidates, pdf.NewName)
+
+	for i := 2; ; i++ {
+		for _, name := range candidates {
+			key := strings.ToLower(name)
+			if owner, ok := n.claimed[key]; ok && owner != pdf.Filename {
+				continue
+			}
+			owner, known := n.jr.Owner(name)
+			if known && owner != pdf.Filename || !known && exists(filepath.Join(n.out, name)) {
+				continue
+			}
+
+			n.claimed[key] = pdf.Filename
+			pdf.NewName = name
+			return pdf, known
+		}
+		candidates = []string{withNameSuffix(pdf.NewName, fmt.Sprint(i))}
+	}
+}
+
+func watchCompress(ctx context.Context, s *state, b backend, jr *journal.Journal, names *watchNames, path string, opts watchOptions) error {
+	named := newPDFConfig(path, opts.manifest)
+	pdf, own := names.claim(named)
+	fmt.Println("Compressing:", pdf.Filename)
+	if pdf.NewName != named.NewName {
+		fmt.Printf("%s --- Named %s, %s belongs to another file\n", pdf.Filename, pdf.NewName, named.NewName)
+	}
+
+	// A file dropped again under the same name is a new version of it. Only
+	// its own earlier output may be replaced, any other file is a collision.
+	compressOpts := opts.compress
+	compressOpts.overwrite = own
+
+	// A file compressed before under the same path is a new scan.
+	entry := jr.Get(pdf.Filename, pdf.NewName)
+	if entry.State == journal.Verified {
+		if err := jr.Update(journal.Entry{Filename: pdf.Filename, NewName: pdf.NewName, State: journal.Pending}); err != nil {
+			return err
+		}
+	}
+
+	var e reportEntry
+	if err := compressPDF(ctx, s, b, jr, pdf, compressOpts, &e); err != nil {
+		fmt.Println(pdf.Filename, "--- Error:", err)
+		return err
+	}
+
+	if e.Status == statusKeptOriginal {
+		fmt.Println(pdf.Filename, "--- Kept the original, the compressed file was not smaller")
+		return nil
+	}
+	fmt.Println(pdf.Filename, "--- Compressed correctly:", filepath.Join(s.wdir, pdf.NewName))
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+)
+
+// WatermarkConfig is the stamp of a pdf, either a text or an image. In the
+// manifest it overrides, field by field, the flags of the watermark command.
+type WatermarkConfig struct {
+	Text  string `json:"text,omitempty"`
+	Image string `json:"image,omitempty"`
+	// Position is a vertical and a horizontal position, like top-right or
+	// middle-center.
+	Position string `json:"position,omitempty"`
+	// Opacity is a percentage, 100 being fully opaque.
+	Opacity  int    `json:"opacity,omitempty"`
+	Rotation int    `json:"rotation,omitempty"`
+	Pages    string `json:"pages,omitempty"`
+}
+
+var (
+	verticalPositions   = []string{"top", "middle", "bottom"}
+	horizontalPositions = []string{"left", "center", "right"}
+)
+
+// merge returns w with the fields set in override replaced. Setting a text or
+// an image replaces the other one too.
+func (w WatermarkConfig) merge(override *WatermarkConfig) WatermarkConfig {
+	if override == nil {
+		return w
+	}
+
+	if override.Text != "" || override.Image != "" {
+		w.Text, w.Image = override.Text, override.Image
+	}
+	if override.Position != "" {
+		w.Position = override.Position
+	}
+	if override.Opacity != 0 {
+		w.Opacity = override.Opacity
+	}
+	if override.Rotation != 0 {
+		w.Rotation = override.Rotation
+	}
+	if override.Pages != "" {
+		w.Pages = override.Pages
+	}
+	return w
+}
+
+// options validates w and returns the options of the watermark tool for a
+// pdf with the given pages, -1 when they are unknown.
+func (w WatermarkConfig) options(pages int) (map[string]any, error) {
+	if (w.Text == "") == (w.Image == "") {
+		return nil, fmt.Errorf("the watermark needs either a text or an image")
+	}
+
+	vertical, horizontal, _ := strings.Cut(w.Position, "-")
+	if horizontal == "" {
+		horizontal = "center"
+	}
+	if !slices.Contains(verticalPositions, vertical) || !slices.Contains(horizontalPositions, horizontal) {
+		return nil, fmt.Errorf("invalid position %q, use %s and %s, like top-right", w.Position,
+			strings.Join(verticalPositions, "|"), strings.Join(horizontalPositions, "|"))
+	}
+	if w.Opacity < 1 || w.Opacity > 100 {
+		return nil, fmt.Errorf("invalid opacity %d, use a percentage from 1 to 100", w.Opacity)
+	}
+	if w.Rotation < 0 || w.Rotation > 360 {
+		return nil, fmt.Errorf("invalid rotation %d, use degrees from 0 to 360", w.Rotation)
+	}
+
+	pagesOption := "all"
+	if w.Pages != "" && w.Pages != "all" {
+		if pages < 0 {
+			return nil, fmt.Errorf("the pages of the pdf can't be counted to stamp %q", w.Pages)
+		}
+		ranges, err := parseRanges(w.Pages, pages)
+		if err != nil {
+			return nil, err
+		}
+		pagesOption = joinRanges(ranges, ",")
+	}
+
+	options := map[string]any{
+		"vertical_position":   vertical,
+		"horizontal_position": horizontal,
+		"transparency":        w.Opacity,
+		"rotation":            w.Rotation,
+		"pages":               pagesOption,
+	}
+	if w.Text != "" {
+		options["mode"] = "text"
+		options["text"] = w.Text
+	} else {
+		options["mode"] = "image"
+	}
+	return options, nil
+}
+
+type watermarkOptions struct {
+	watermark WatermarkConfig
+	out       string
+	overwrite bool
+	api       apiOptions
+}
+
+func HandlerWatermark(ctx context.Context, s *state, cmd command) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	var (
+		help      = fs.Bool(initHelpFlag, false, "Show help message")
+		text      = fs.String(textFlag, "", "Stamp a text -text <text>")
+		image     = fs.String(imageFlag, "", "Stamp an image -image <logo.png>")
+		position  = fs.String(positionFlag, "middle-center", "Where the stamp goes -position <top|middle|bottom>-<left|center|right>")
+		opacity   = fs.Int(opacityFlag, 100, "Opacity of the stamp, in percent -opacity <1-100>")
+		rotation  = fs.Int(rotationFlag, 0, "Rotation of the stamp, in degrees -rotation <0-360>")
+		pages     = fs.String(pagesFlag, "all", "Pages that are stamped -pages <all|1-3,7,10->")
+		out       = fs.String(outFlag, "", "Directory the stamped pdfs are written to -out <dir>\nDefaults to the current directory.")
+		overwrite = fs.Bool(overwriteFlag, false, "Replace files that already have the name of a stamped pdf -overwrite")
+	)
+	api := addAPIFlags(fs, s.cfg.Settings)
+	fs.Parse(cmd.Arguments)
+
+	if *help {
+		fs.Usage()
+		return nil
+	}
+
+	apiOpts, err := api.options()
+	if err != nil {
+		return err
+	}
+
+	// Without files, the manifest says which pdfs are stamped and how.
+	manifest, ok, err := toolPDFs(s, fs.Args())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("Error: watermark requires the pdfs to stamp or a config file.\nUsage: pressgo %s -%s <text>|-%s <image> <pdf>...\n", watermarkCmd, textFlag, imageFlag)
+		os.Exit(1)
+	}
+
+	limited := *s
+	limited.client = withRateLimit(s.client, apiOpts.rateLimit)
+	b, err := newILovePDFBackend(ctx, &limited, nil, apiOpts.retry)
+	if err != nil {
+		return err
+	}
+
+	opts := watermarkOptions{
+		watermark: WatermarkConfig{Text: *text, Image: *image, Position: *position, Opacity: *opacity, Rotation: *rotation, Pages: *pages},
+		out:       *out,
+		overwrite: *overwrite,
+		api:       apiOpts,
+	}
+	failed, stamped := 0, 0
+	for _, pdf := range manifest {
+		w := opts.watermark.merge(pdf.Watermark)
+		if w.Text == "" && w.Image == "" {
+			fmt.Println(pdf.Filename, "--- Skipped, it has no watermark")
+			continue
+		}
+
+		if err := watermarkPDF(ctx, s, b, pdf, w, opts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Println(pdf.Filename, "--- Error:", err)
+			failed++
+			continue
+		}
+		stamped++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pdfs could not be stamped", failed, failed+stamped)
+	}
+	return nil
+}
+
+// watermarkPDF stamps a pdf and writes it under its new name, keeping the
+// title and author of the manifest.
+func watermarkPDF(ctx context.Context, s *state, b *ilovePDFBackend, pdf PDFsConfig, w WatermarkConfig, opts watermarkOptions) error {
+	path := resolvePath(s, pdf.Filename)
+	pages, err := pdfPages(path)
+	if err != nil {
+		return err
+	}
+
+	options, err := w.options(pages)
+	if err != nil {
+		return err
+	}
+
+	var uploads map[string]string
+	if w.Image != "" {
+		image := resolvePath(s, w.Image)
+		if _, err := os.Stat(image); err != nil {
+			return fmt.Errorf("the watermark image can't be read: %v", err)
+		}
+		uploads = map[string]string{"image": image}
+	}
+
+	output := outputPath(s, pdf, compressOptions{out: opts.out})
+	// Unlike a compressed copy, a stamped one can't stand in for the clean
+	// original, so it is never written over it.
+	if sameFile(path, output) {
+		return fmt.Errorf("it would be stamped over itself, use -%s or a different new_name", outFlag)
+	}
+	if !opts.overwrite && overwrites(path, output) {
+		return fmt.Errorf("%s already exists, use -%s to replace it", output, overwriteFlag)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+
+	part := output + partExt
+	defer os.Remove(part)
+
+	fmt.Println("Stamping:", pdf.Filename)
+	u, err := b.runTool(ctx, toolTask{
+		tool:    toolWatermark,
+		files:   []string{path},
+		meta:    iloveapi.Meta{Title: pdf.Title, Author: pdf.Author},
+		options: options,
+		uploads: uploads,
+	}, part)
+	if err != nil {
+		return err
+	}
+	if err := verifyPages(part, pages); err != nil {
+		return err
+	}
+	if err := os.Rename(part, output); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s --- Stamped: %s using %d credits\n", pdf.Filename, output, u.credits)
+	return nil
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"github.com/fernando8franco/pressgo/internal/config"
 )
@@ -17,7 +22,15 @@ type state struct {
 }
 
 func main() {
-	conf, err := config.Read()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Once interrupted, a second signal kills the program right away.
+		<-ctx.Done()
+		stop()
+	}()
+
+	conf, err := config.Read(promptPassphrase)
 	if err != nil {
 		log.Fatalf("error reading config file: %v", err)
 	}
@@ -27,20 +40,34 @@ func main() {
 		log.Fatalf("error getting current directory: %v", err)
 	}
 
+	client, err := newHTTPClient()
+	if err != nil {
+		log.Fatalf("error creating http client: %v", err)
+	}
+
 	programState := state{
 		cfg:    &conf,
 		wdir:   wdir,
 		mu:     &sync.RWMutex{},
-		client: &http.Client{},
+		client: client,
 	}
 
 	commands := commands{
-		registeredCommands: make(map[string]func(*state, command) error),
+		registeredCommands: make(map[string]func(context.Context, *state, command) error),
 	}
 
 	commands.Register(credentialsCmd, HandlerCredentials)
 	// commands.Register(initCmd, HandlerInit)
 	commands.Register(compressCmd, HandlerCompress)
+	commands.Register(watchCmd, HandlerWatch)
+	commands.Register(configCmd, HandlerConfig)
+	commands.Register(mergeCmd, HandlerMerge)
+	commands.Register(splitCmd, HandlerSplit)
+	commands.Register(watermarkCmd, HandlerWatermark)
+	commands.Register(protectCmd, HandlerProtect)
+	commands.Register(unlockCmd, HandlerUnlock)
+	commands.Register(imagePDFCmd, HandlerImagePDF)
+	commands.Register(pdfJPGCmd, HandlerPDFJPG)
 
 	if len(os.Args) < 2 {
 		log.Fatal("not enough arguments were provided")
@@ -51,8 +78,17 @@ func main() {
 		Arguments: os.Args[2:],
 	}
 
-	err = commands.Run(&programState, cmd)
-	if err != nil {
-		log.Fatalf("error running the command:\n%v", err)
+	err = commands.Run(ctx, &programState, cmd)
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted")
+		stop()
+		os.Exit(exitInterrupted)
 	}
+	fmt.Fprintf(os.Stderr, "error running the command:\n%v\n", err)
+	stop()
+	os.Exit(exitError)
 }
@@ -0,0 +1,2228 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fernando8franco/pressgo/internal/config"
+	"github.com/fernando8franco/pressgo/internal/fakeapi"
+	"github.com/fernando8franco/pressgo/internal/journal"
+	"github.com/fernando8franco/pressgo/pkg/pdfdoc"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+var testPDF = pdfWithPages(1)
+
+// pdfWithPages builds a minimal, well formed pdf with n empty pages.
+func pdfWithPages(n int) string {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+	}
+	var kids []string
+	for i := range n {
+		kids = append(kids, fmt.Sprintf("%d 0 R", i+3))
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>")
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n)
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.String()
+}
+
+// newTestState returns a state with an empty config in a temporary home,
+// wired to the fake API.
+func newTestState(t *testing.T, srv *fakeapi.Server) *state {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(config.PassphraseEnv, "")
+
+	cfg, err := config.Read(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client, err := newBaseURLClient(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return &state{
+		cfg:    &cfg,
+		wdir:   t.TempDir(),
+		mu:     &sync.RWMutex{},
+		client: client,
+	}
+}
+
+func run(t *testing.T, s *state, name string, args ...string) error {
+	t.Helper()
+	return runContext(t, context.Background(), s, name, args...)
+}
+
+func runContext(t *testing.T, ctx context.Context, s *state, name string, args ...string) error {
+	t.Helper()
+	handlers := map[string]func(context.Context, *state, command) error{
+		credentialsCmd: HandlerCredentials,
+		compressCmd:    HandlerCompress,
+		configCmd:      HandlerConfig,
+		mergeCmd:       HandlerMerge,
+		splitCmd:       HandlerSplit,
+		watermarkCmd:   HandlerWatermark,
+		protectCmd:     HandlerProtect,
+		unlockCmd:      HandlerUnlock,
+		imagePDFCmd:    HandlerImagePDF,
+		pdfJPGCmd:      HandlerPDFJPG,
+	}
+	return handlers[name](ctx, s, command{Name: name, Arguments: args})
+}
+
+func writePDFs(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(testPDF), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func writeManifest(t *testing.T, path string, pdfs []PDFsConfig) {
+	t.Helper()
+	data, err := json.MarshalIndent(pdfs, "", "  ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func assertExists(t *testing.T, path string, exists bool) {
+	t.Helper()
+	_, err := os.Stat(path)
+	if exists && err != nil {
+		t.Errorf("expected %s to exist: %v", path, err)
+	}
+	if !exists && err == nil {
+		t.Errorf("expected %s not to exist", path)
+	}
+}
+
+func TestCredentialsAdd(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 250)
+	s := newTestState(t, srv)
+
+	if err := run(t, s, credentialsCmd, "-add", "me", "key-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg, err := config.Read(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cred, ok := cfg.Credentials["me"]
+	if !ok {
+		t.Fatal("expected the credential to be saved")
+	}
+	if cred.Key != "key-1" || cred.Credits != 250 || !cred.Status || cred.Token == "" {
+		t.Errorf("got credential %+v", cred)
+	}
+}
+
+func TestCredentialsAdd_InvalidKey(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	s := newTestState(t, srv)
+
+	if err := run(t, s, credentialsCmd, "-add", "me", "unknown"); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+
+	if len(s.cfg.Credentials) != 0 {
+		t.Errorf("expected no credentials, got %+v", s.cfg.Credentials)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Report One.pdf", "sub/Other File.PDF")
+
+	if err := run(t, s, credentialsCmd, "-add", "me", "key-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, compressCmd, "-init", "Author", "Title"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The author comes first, as it always has.
+	for _, pdf := range mustManifest(t, filepath.Join(s.wdir, configFile)) {
+		if pdf.Author != "Author" || pdf.Title != "Title" {
+			t.Errorf("got author %q and title %q", pdf.Author, pdf.Title)
+		}
+	}
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "report-one.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "other-file.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "Report One.pdf"), false)
+	assertExists(t, filepath.Join(s.wdir, "sub", "Other File.PDF"), false)
+
+	if got := srv.Credits("key-1"); got != 8 {
+		t.Errorf("got %d credits left, want 8", got)
+	}
+	if got := len(srv.Processed()); got != 2 {
+		t.Errorf("got %d processed tasks, want 2", got)
+	}
+}
+
+func TestCompress_Failover(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-a", 1)
+	srv.AddKey("key-b", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "two.pdf", "three.pdf")
+
+	if err := run(t, s, credentialsCmd, "-add", "a", "key-a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, credentialsCmd, "-add", "b", "key-b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, compressCmd, "-init", "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := srv.Credits("key-a") + srv.Credits("key-b"); got != 8 {
+		t.Errorf("got %d credits left, want 8", got)
+	}
+
+	cfg, _ := config.Read(nil)
+	if cfg.Credentials["a"].Status || !cfg.Credentials["b"].Status {
+		t.Errorf("expected credential b to be active, got %+v", cfg.Credentials)
+	}
+}
+
+func TestCompress_NoCreditsLeft(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-a", 1)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "two.pdf")
+
+	run(t, s, credentialsCmd, "-add", "a", "key-a")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	err := run(t, s, compressCmd)
+	if err == nil || !strings.Contains(err.Error(), config.ErrNoCredits.Error()) {
+		t.Fatalf("got %v, want %v", err, config.ErrNoCredits)
+	}
+}
+
+func TestCompress_Resume(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	srv.FailNext(fakeapi.StepDownload, http.StatusBadGateway)
+	if err := run(t, s, compressCmd, "-max-attempts", "1"); err == nil {
+		t.Fatal("expected the download failure to be reported")
+	}
+
+	jr, err := journal.Open(filepath.Join(s.wdir, journalFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := jr.Get(filepath.Join(s.wdir, "one.pdf"), "").State; got != journal.Processed {
+		t.Errorf("got state %q, want %q", got, journal.Processed)
+	}
+
+	if err := run(t, s, compressCmd, "-resume"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "one.pdf"), true)
+	if got := srv.Credits("key-1"); got != 9 {
+		t.Errorf("got %d credits left, want 9: the task was processed twice", got)
+	}
+}
+
+func TestCompress_LocalBackend(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Scan 01.pdf")
+
+	run(t, s, compressCmd, "-init", "Author", "base")
+	// The test pdf is already as small as it gets.
+	if err := run(t, s, compressCmd, "-backend", "local", "-replace-if-smaller"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"), true)
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("expected no API calls, got %d processed tasks", got)
+	}
+}
+
+func TestCompress_CompressionLevel(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "archive.pdf", "contract.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-init", "-level", "extreme", "Author", "Title"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	manifest := filepath.Join(s.wdir, configFile)
+	pdfs, err := getConfigPdfsFile(manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, pdf := range pdfs {
+		if pdf.CompressionLevel != levelExtreme || pdf.Title != "Title" || pdf.Author != "Author" {
+			t.Errorf("got manifest entry %+v", pdf)
+		}
+		if filepath.Base(pdf.Filename) == "contract.pdf" {
+			pdfs[i].CompressionLevel = levelRecommended
+		}
+	}
+	writeManifest(t, manifest, pdfs)
+
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	levels := map[string]bool{}
+	for _, body := range srv.Processed() {
+		levels[body["compression_level"].(string)] = true
+	}
+	if !levels[levelExtreme] || !levels[levelRecommended] {
+		t.Errorf("expected both levels to reach the API, got %v", levels)
+	}
+}
+
+func TestCompress_InvalidCompressionLevel(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "a.pdf")
+
+	if err := run(t, s, compressCmd, "-init", "-level", "maximum", "Author", "Title"); err == nil {
+		t.Error("expected an error for an unknown -level")
+	}
+
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "a.pdf", NewName: "a.pdf", CompressionLevel: "maximum"},
+	})
+	if err := run(t, s, compressCmd, "-backend", "local"); err == nil {
+		t.Error("expected an error for an unknown compression_level")
+	}
+}
+
+func TestCompress_InvalidOutput(t *testing.T) {
+	tests := map[string]string{
+		"truncated":  testPDF[:len(testPDF)/2],
+		"not a pdf":  "<html>Service unavailable</html>",
+		"more pages": pdfWithPages(2),
+	}
+
+	for name, output := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := fakeapi.New()
+			defer srv.Close()
+			srv.AddKey("key-1", 10)
+			srv.Transform = func(string, [][]byte, map[string]any) []byte { return []byte(output) }
+			s := newTestState(t, srv)
+			writePDFs(t, s.wdir, "Scan 01.pdf")
+
+			run(t, s, credentialsCmd, "-add", "me", "key-1")
+			run(t, s, compressCmd, "-init", "Author", "base")
+			if err := run(t, s, compressCmd); err == nil {
+				t.Fatal("expected the invalid output to be reported")
+			}
+
+			assertExists(t, filepath.Join(s.wdir, "Scan 01.pdf"), true)
+			assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"), false)
+			assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"+partExt), false)
+
+			jr, err := journal.Open(filepath.Join(s.wdir, journalFile))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := jr.Get(filepath.Join(s.wdir, "Scan 01.pdf"), "").State; got != journal.Processed {
+				t.Errorf("got state %q, want %q", got, journal.Processed)
+			}
+		})
+	}
+}
+
+func TestCompress_LargerOutput(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = func(_ string, files [][]byte, _ map[string]any) []byte {
+		return append(append([]byte(nil), files[0]...), strings.Repeat(" ", 100)...)
+	}
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Scan 01.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	err := run(t, s, compressCmd)
+	if err == nil {
+		t.Fatal("expected the larger output to be reported")
+	}
+	assertExists(t, filepath.Join(s.wdir, "Scan 01.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"), false)
+
+	if err := run(t, s, compressCmd, "-resume", "-replace-if-smaller"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.wdir, "scan-01.pdf"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != testPDF {
+		t.Error("expected the original to be kept under the new name")
+	}
+	assertExists(t, filepath.Join(s.wdir, "Scan 01.pdf"), false)
+	assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"+partExt), false)
+	if got := srv.Credits("key-1"); got != 9 {
+		t.Errorf("got %d credits left, want 9: the task was processed twice", got)
+	}
+}
+
+func TestCompress_KeepOriginals(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Scan 01.pdf", "same.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	if err := run(t, s, compressCmd, "-keep-originals"); err == nil {
+		t.Fatal("expected an error for the pdf that already has its new name")
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "Scan 01.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "scan-01.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "same.pdf"), true)
+	if got := srv.Credits("key-1"); got != 9 {
+		t.Errorf("got %d credits left, want 9", got)
+	}
+}
+
+func TestCompress_Report(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = func(_ string, files [][]byte, _ map[string]any) []byte {
+		if strings.Contains(string(files[0]), "/Count 2") {
+			return []byte("truncated")
+		}
+		return files[0]
+	}
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "Two.pdf")
+	if err := os.WriteFile(filepath.Join(s.wdir, "broken.pdf"), []byte(pdfWithPages(2)), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	if err := run(t, s, compressCmd, "-report", "report.json"); err == nil {
+		t.Fatal("expected the broken pdf to be reported")
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Files != 3 || rep.Totals.Compressed != 2 || rep.Totals.Failed != 1 || rep.Totals.Credits != 3 {
+		t.Errorf("got totals %+v", rep.Totals)
+	}
+	if want := int64(2 * len(testPDF)); rep.Totals.BytesBefore != want || rep.Totals.BytesAfter != want {
+		t.Errorf("got %d bytes before and %d after, want %d", rep.Totals.BytesBefore, rep.Totals.BytesAfter, want)
+	}
+	for _, e := range rep.Files {
+		if e.CredentialID != "me" || e.Credits != 1 {
+			t.Errorf("got entry %+v", e)
+		}
+		if filepath.Base(e.OriginalPath) == "broken.pdf" && (e.Status != statusFailed || e.Error == "") {
+			t.Errorf("got entry %+v for the broken pdf", e)
+		}
+		if e.Status == statusCompressed && e.Ratio != 1 {
+			t.Errorf("got ratio %v, want 1", e.Ratio)
+		}
+	}
+
+	if err := run(t, s, compressCmd, "-resume", "-report", "report.csv"); err == nil {
+		t.Fatal("expected the broken pdf to be reported")
+	}
+
+	file, err := os.Open(filepath.Join(s.wdir, "report.csv"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Header, three files and the totals.
+	if len(records) != 5 || records[0][0] != "original_path" || records[4][0] != "TOTAL" {
+		t.Errorf("got csv report %q", records)
+	}
+
+	if err := run(t, s, compressCmd, "-resume", "-report", "report.txt"); err == nil {
+		t.Error("expected an error for an unknown report format")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+
+	inbox := filepath.Join(s.wdir, "inbox")
+	writePDFs(t, inbox, "Scan 01.pdf")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- watchPDFs(ctx, s, inbox, watchOptions{
+			manifest: manifestOptions{title: titleFilename, author: "Scanner", level: levelLow},
+			backend:  backendILovePDF,
+			interval: 20 * time.Millisecond,
+			poll:     true,
+		})
+	}()
+
+	// One file is there before the watch starts and the other one arrives later.
+	writePDFs(t, inbox, "scans/Scan 02.pdf")
+
+	out := filepath.Join(inbox, watchOutDir)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Processed()) < 2 || len(verifiedFiles(t, out)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pdfs to be compressed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(out, "scan-01.pdf"), true)
+	assertExists(t, filepath.Join(out, "scan-02.pdf"), true)
+	assertExists(t, filepath.Join(inbox, "Scan 01.pdf"), false)
+	assertExists(t, filepath.Join(inbox, "scans", "Scan 02.pdf"), false)
+
+	for _, body := range srv.Processed() {
+		meta, _ := body["metas"].(map[string]any)
+		if title, _ := meta["Title"].(string); !strings.HasPrefix(title, "Scan 0") || body["compression_level"] != levelLow {
+			t.Errorf("got process request %v", body)
+		}
+	}
+}
+
+func TestWatch_NoCredits(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 1)
+	s := newTestState(t, srv)
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+
+	inbox := filepath.Join(s.wdir, "inbox")
+	writePDFs(t, inbox, "a.pdf", "b.pdf", "c.pdf")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- watchPDFs(context.Background(), s, inbox, watchOptions{
+			manifest: manifestOptions{title: titleFilename, level: levelRecommended},
+			backend:  backendILovePDF,
+			interval: 20 * time.Millisecond,
+			poll:     true,
+		})
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, config.ErrNoCredits) {
+			t.Errorf("got %v, want %v", err, config.ErrNoCredits)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch didn't stop when the credits ran out")
+	}
+}
+
+func TestWatch_Collisions(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+
+	inbox := filepath.Join(s.wdir, "inbox")
+	for name, pages := range map[string]int{"a/Report.pdf": 1, "b/report!.pdf": 2} {
+		path := filepath.Join(inbox, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(pdfWithPages(pages)), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- watchPDFs(ctx, s, inbox, watchOptions{
+			manifest: manifestOptions{title: titleFilename, level: levelRecommended},
+			backend:  backendILovePDF,
+			interval: 20 * time.Millisecond,
+			poll:     true,
+		})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for exists(filepath.Join(inbox, "a", "Report.pdf")) || exists(filepath.Join(inbox, "b", "report!.pdf")) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pdfs to be compressed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Both documents are kept, whichever was compressed first.
+	var pages []int
+	for _, name := range []string{"report.pdf", "report-2.pdf"} {
+		data, err := os.ReadFile(filepath.Join(inbox, watchOutDir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := pdfdoc.Verify(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages = append(pages, n)
+	}
+	slices.Sort(pages)
+	if !slices.Equal(pages, []int{1, 2}) {
+		t.Errorf("got outputs with %v pages, want [1 2]", pages)
+	}
+}
+
+func TestWatch_Overwrite(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+
+	inbox := filepath.Join(s.wdir, "inbox")
+	out := filepath.Join(inbox, watchOutDir)
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "other.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writePDFs(t, inbox, "Other.pdf")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- watchPDFs(ctx, s, inbox, watchOptions{
+			manifest: manifestOptions{title: titleFilename, level: levelRecommended},
+			backend:  backendILovePDF,
+			interval: 20 * time.Millisecond,
+			poll:     true,
+		})
+	}()
+
+	waitCompressed := func(processed int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(srv.Processed()) < processed || exists(filepath.Join(inbox, "Other.pdf")) {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the pdf to be compressed")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	waitCompressed(1)
+	// The same file dropped again replaces its own output.
+	writePDFs(t, inbox, "Other.pdf")
+	waitCompressed(2)
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(out, "other.pdf")); string(data) != "someone else's" {
+		t.Errorf("got %q, want the file that was there before", data)
+	}
+	assertExists(t, filepath.Join(out, "other-2.pdf"), true)
+	assertExists(t, filepath.Join(out, "other-3.pdf"), false)
+}
+
+// verifiedFiles returns the files the journal in dir records as verified.
+func verifiedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	jr, err := journal.Open(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil
+	}
+
+	var verified []string
+	for _, name := range []string{"Scan 01.pdf", "scans/Scan 02.pdf"} {
+		path := filepath.Join(filepath.Dir(dir), name)
+		if jr.Get(path, "").State == journal.Verified {
+			verified = append(verified, path)
+		}
+	}
+	return verified
+}
+
+func TestCompress_TokenRefresh(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "two.pdf", "three.pdf", "four.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	stale := s.cfg.Credentials["me"].Token
+
+	srv.ExpireTokens()
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One token for -add and a single refresh shared by every worker.
+	if got := srv.TokensIssued(); got != 2 {
+		t.Errorf("got %d tokens issued, want 2", got)
+	}
+	if got := len(srv.Processed()); got != 4 {
+		t.Errorf("got %d processed tasks, want 4", got)
+	}
+
+	cfg, _ := config.Read(nil)
+	if token := cfg.Credentials["me"].Token; token == "" || token == stale {
+		t.Errorf("expected the refreshed token to be saved, got %q", token)
+	}
+}
+
+func TestCompress_Retries(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	srv.FailNext(fakeapi.StepStart, http.StatusServiceUnavailable)
+	srv.FailNext(fakeapi.StepUpload, http.StatusTooManyRequests)
+	srv.FailNext(fakeapi.StepProcess, http.StatusTooManyRequests)
+	srv.FailNext(fakeapi.StepDownload, http.StatusBadGateway)
+	if err := run(t, s, compressCmd, "-retry-delay", "1ms", "-report", "report.json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Retries != 4 || rep.Files[0].Retries != 4 {
+		t.Errorf("got %d retries, want 4", rep.Totals.Retries)
+	}
+	if got := srv.Credits("key-1"); got != 9 {
+		t.Errorf("got %d credits left, want 9", got)
+	}
+}
+
+func TestCompress_RetryAfter(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.RetryAfter = "1"
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	srv.FailNext(fakeapi.StepStart, http.StatusTooManyRequests)
+	start := time.Now()
+	if err := run(t, s, compressCmd, "-retry-delay", "1ms"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", elapsed)
+	}
+}
+
+func TestCompress_ProcessNotRetried(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	// The task may have been processed before the error, so sending it
+	// again could charge twice.
+	srv.FailNext(fakeapi.StepProcess, http.StatusBadGateway)
+	if err := run(t, s, compressCmd, "-retry-delay", "1ms", "-report", "report.json"); err == nil {
+		t.Fatal("expected the pdf to fail")
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Retries != 0 || rep.Totals.Failed != 1 {
+		t.Errorf("got %d retries and %d failed, want 0 and 1", rep.Totals.Retries, rep.Totals.Failed)
+	}
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("got %d processed tasks, want 0", got)
+	}
+	assertExists(t, filepath.Join(s.wdir, "one.pdf"), true)
+}
+
+func TestMerge_ProcessNotRetried(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "a.pdf", "b.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	srv.FailNext(fakeapi.StepProcess, http.StatusInternalServerError)
+	if err := run(t, s, mergeCmd, "-retry-delay", "1ms", "a.pdf", "b.pdf"); err == nil {
+		t.Fatal("expected the merge to fail")
+	}
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("got %d processed tasks, want 0", got)
+	}
+	assertExists(t, filepath.Join(s.wdir, mergeName+pdfExt), false)
+}
+
+func TestCompress_RetriesExhausted(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	if err := run(t, s, configCmd, "-set", config.SettingMaxAttempts, "2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 2 {
+		srv.FailNext(fakeapi.StepStart, http.StatusServiceUnavailable)
+	}
+	if err := run(t, s, compressCmd, "-retry-delay", "1ms", "-report", "report.json"); err == nil {
+		t.Fatal("expected the pdf to fail once the attempts run out")
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Retries != 1 || !strings.Contains(rep.Files[0].Error, "after 2 attempts") {
+		t.Errorf("got report entry %+v", rep.Files[0])
+	}
+}
+
+func TestCompress_FatalErrorNotRetried(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	srv.FailNext(fakeapi.StepUpload, http.StatusBadRequest)
+	if err := run(t, s, compressCmd, "-retry-delay", "1ms", "-report", "report.json"); err == nil {
+		t.Fatal("expected the rejected upload to be reported")
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Retries != 0 || rep.Totals.Failed != 1 {
+		t.Errorf("got totals %+v", rep.Totals)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, baseDelay: 100 * time.Millisecond}
+
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+		if got := p.delay(retry, 0); got < want/2 || got > want {
+			t.Errorf("retry %d: got %v, want between %v and %v", retry, got, want/2, want)
+		}
+	}
+	if got := p.delay(40, 0); got > maxRetryDelay {
+		t.Errorf("got %v, want at most %v", got, maxRetryDelay)
+	}
+	if got := p.delay(1, 3*time.Second); got != 3*time.Second {
+		t.Errorf("got %v, want the Retry-After of 3s", got)
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got := parseRetryAfter("7", now); got != 7*time.Second {
+		t.Errorf("got %v, want 7s", got)
+	}
+	if got := parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now); got != time.Minute {
+		t.Errorf("got %v, want 1m", got)
+	}
+}
+
+func readReport(t *testing.T, path string) *runReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rep := &runReport{}
+	if err := json.Unmarshal(data, rep); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rep
+}
+
+// concurrencyBackend copies the pdfs and records how many it was asked to
+// compress at the same time.
+type concurrencyBackend struct {
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (b *concurrencyBackend) compress(ctx context.Context, pdf PDFsConfig, src, dst string) (usage, error) {
+	b.mu.Lock()
+	b.running++
+	b.most = max(b.most, b.running)
+	b.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return usage{}, err
+	}
+	return usage{}, os.WriteFile(dst, data, 0644)
+}
+
+func TestCompressPDFs_Workers(t *testing.T) {
+	for _, workers := range []int{1, 2, 5} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			srv := fakeapi.New()
+			defer srv.Close()
+			s := newTestState(t, srv)
+			names := []string{"a.pdf", "b.pdf", "c.pdf", "d.pdf", "e.pdf", "f.pdf"}
+			writePDFs(t, s.wdir, names...)
+
+			var pdfs []PDFsConfig
+			for _, name := range names {
+				pdfs = append(pdfs, PDFsConfig{Filename: name, NewName: "new-" + name})
+			}
+
+			b := &concurrencyBackend{}
+			jr := journal.New(filepath.Join(s.wdir, journalFile))
+			rep := newRunReport()
+			if err := compressPDFs(context.Background(), s, b, jr, pdfs, compressOptions{workers: workers}, rep); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if b.most != workers {
+				t.Errorf("got %d pdfs compressed at once, want %d", b.most, workers)
+			}
+			if rep.Totals.Compressed != len(names) {
+				t.Errorf("got %d compressed pdfs, want %d", rep.Totals.Compressed, len(names))
+			}
+		})
+	}
+}
+
+func TestCompress_RateLimit(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "two.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	if err := run(t, s, configCmd, "-set", config.SettingRateLimit, "20"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each pdf takes four requests: start, upload, process and download.
+	start := time.Now()
+	if err := run(t, s, compressCmd, "-workers", "2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed, want := time.Since(start), 7*time.Second/20; elapsed < want {
+		t.Errorf("8 requests took %v, want at least %v at 20 per second", elapsed, want)
+	}
+
+	if err := run(t, s, compressCmd, "-workers", "0"); err == nil {
+		t.Error("expected an error for -workers 0")
+	}
+}
+
+func TestCompress_Interrupted(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "a.pdf", "b.pdf", "c.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	// The run is interrupted while the second pdf is being processed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	processed := 0
+	srv.Transform = func(_ string, files [][]byte, _ map[string]any) []byte {
+		if processed++; processed == 2 {
+			cancel()
+		}
+		return files[0]
+	}
+
+	err := runContext(t, ctx, s, compressCmd, "-workers", "1", "-report", "report.json")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "a.pdf"), true)
+	for _, name := range []string{"b.pdf", "c.pdf"} {
+		data, err := os.ReadFile(filepath.Join(s.wdir, name))
+		if err != nil || string(data) != testPDF {
+			t.Errorf("expected the original %s to be untouched: %v", name, err)
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(s.wdir, "*"+partExt))
+	if len(matches) != 0 {
+		t.Errorf("expected no partial outputs, got %v", matches)
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Compressed != 1 || rep.Totals.Interrupted != 1 {
+		t.Errorf("got totals %+v", rep.Totals)
+	}
+
+	srv.Transform = nil
+	if err := run(t, s, compressCmd, "-resume"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCompress_DryRun(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 2)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "two.pdf")
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+
+	if err := run(t, s, compressCmd, "-dry-run"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertExists(t, filepath.Join(s.wdir, "one.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, journalFile), false)
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("got %d processed tasks, want 0", got)
+	}
+
+	manifest := filepath.Join(s.wdir, configFile)
+	writeManifest(t, manifest, []PDFsConfig{
+		{Filename: "one.pdf", NewName: "same.pdf"},
+		{Filename: "two.pdf", NewName: "Same.pdf"},
+		{Filename: "gone.pdf", NewName: "gone.pdf"},
+	})
+	p := planDryRun(s, mustManifest(t, manifest), journal.New(filepath.Join(s.wdir, journalFile)), backendILovePDF, compressOptions{})
+	if p.collisions != 2 || p.missing != 1 || p.needed != 2 || p.available != 2 {
+		t.Errorf("got plan %+v", p)
+	}
+	if err := run(t, s, compressCmd, "-dry-run"); err == nil {
+		t.Error("expected the collisions and the missing pdf to fail the dry run")
+	}
+
+	writePDFs(t, s.wdir, "three.pdf")
+	writeManifest(t, manifest, []PDFsConfig{
+		{Filename: "one.pdf", NewName: "one-c.pdf"},
+		{Filename: "two.pdf", NewName: "two-c.pdf"},
+		{Filename: "three.pdf", NewName: "three-c.pdf"},
+	})
+	if err := run(t, s, compressCmd, "-dry-run"); err == nil {
+		t.Error("expected a dry run that needs more credits than available to fail")
+	}
+	if err := run(t, s, compressCmd, "-dry-run", "-backend", "local"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func mustManifest(t *testing.T, path string) []PDFsConfig {
+	t.Helper()
+	pdfs, err := getConfigPdfsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return pdfs
+}
+
+func TestResolveCollisions(t *testing.T) {
+	files := []string{"a/Report.pdf", "b/report!.pdf", "c/report-2.pdf", "b/Other.pdf"}
+	tests := map[string][]string{
+		collisionSuffix: {"report.pdf", "report-3.pdf", "report-2.pdf", "other.pdf"},
+		collisionParent: {"a-report.pdf", "b-report.pdf", "report-2.pdf", "other.pdf"},
+	}
+
+	for strategy, want := range tests {
+		t.Run(strategy, func(t *testing.T) {
+			var pdfs []PDFsConfig
+			for _, file := range files {
+				pdfs = append(pdfs, newPDFConfig(file, manifestOptions{title: titleFilename}))
+			}
+			resolveCollisions(pdfs, strategy)
+
+			for i, pdf := range pdfs {
+				if pdf.NewName != want[i] {
+					t.Errorf("got %q for %s, want %q", pdf.NewName, pdf.Filename, want[i])
+				}
+			}
+		})
+	}
+
+	pdfs := []PDFsConfig{
+		newPDFConfig("a/Report.pdf", manifestOptions{}),
+		newPDFConfig("b/report!.pdf", manifestOptions{}),
+	}
+	resolveCollisions(pdfs, collisionHash)
+	if pdfs[0].NewName == pdfs[1].NewName || !strings.HasPrefix(pdfs[0].NewName, "report-") || len(pdfs[0].NewName) != len("report-")+shortHashLen+len(pdfExt) {
+		t.Errorf("got names %q and %q", pdfs[0].NewName, pdfs[1].NewName)
+	}
+}
+
+func TestCompress_Collisions(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "a/Report.pdf", "b/report!.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-init", "-collisions", "parent", "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "a-report.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "b-report.pdf"), true)
+
+	if err := run(t, s, compressCmd, "-init", "-collisions", "random", "Author", "base"); err == nil {
+		t.Error("expected an error for an unknown collision strategy")
+	}
+}
+
+func TestCompress_Overwrite(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Scan 01.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	existing := filepath.Join(s.wdir, "scan-01.pdf")
+	if err := os.WriteFile(existing, []byte("keep me"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := run(t, s, compressCmd); err == nil {
+		t.Fatal("expected an error for the existing output")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "keep me" {
+		t.Error("expected the existing file to be left untouched")
+	}
+	if got := srv.Credits("key-1"); got != 10 {
+		t.Errorf("got %d credits left, want 10", got)
+	}
+
+	if err := run(t, s, compressCmd, "-resume", "-overwrite"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != testPDF {
+		t.Error("expected the existing file to be replaced")
+	}
+}
+
+func TestCompress_RenameFails(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "same.pdf")
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "one.pdf", NewName: "taken.pdf"},
+		{Filename: "same.pdf", NewName: "same.pdf"},
+	})
+	// Nothing can be renamed onto a directory with files.
+	if err := os.MkdirAll(filepath.Join(s.wdir, "taken.pdf", "keep"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-overwrite"); err == nil {
+		t.Fatal("expected an error for the output that can't be written")
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "one.pdf"), true)
+	// An output with the name of its original replaces it.
+	assertExists(t, filepath.Join(s.wdir, "same.pdf"), true)
+}
+
+func TestCompress_Mirror(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "Case 01/Scan A.pdf", "Case 02/Sub/Scan B.pdf", "top.pdf")
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-init", "Author", "base")
+	if err := run(t, s, compressCmd, "-out", "out", "-mirror", "-slug-dirs"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "out", "case-01", "scan-a.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "out", "case-02", "sub", "scan-b.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "out", "top.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "Case 01", "Scan A.pdf"), false)
+}
+
+func TestOutputPath(t *testing.T) {
+	s := &state{wdir: filepath.FromSlash("/work")}
+	pdf := PDFsConfig{Filename: filepath.FromSlash("/work/Case 01/Scan A.pdf"), NewName: "scan-a.pdf"}
+	outside := PDFsConfig{Filename: filepath.FromSlash("/elsewhere/Scan A.pdf"), NewName: "scan-a.pdf"}
+
+	tests := []struct {
+		pdf  PDFsConfig
+		opts compressOptions
+		want string
+	}{
+		{pdf, compressOptions{}, "/work/scan-a.pdf"},
+		{pdf, compressOptions{out: "out"}, "/work/out/scan-a.pdf"},
+		{pdf, compressOptions{out: "/tmp/out"}, "/tmp/out/scan-a.pdf"},
+		{pdf, compressOptions{mirror: true}, "/work/Case 01/scan-a.pdf"},
+		{pdf, compressOptions{out: "out", mirror: true, slugDirs: true}, "/work/out/case-01/scan-a.pdf"},
+		{outside, compressOptions{out: "out", mirror: true}, "/work/out/scan-a.pdf"},
+	}
+
+	for _, tt := range tests {
+		if got := outputPath(s, tt.pdf, tt.opts); got != filepath.FromSlash(tt.want) {
+			t.Errorf("got %q for %+v, want %q", got, tt.opts, tt.want)
+		}
+	}
+}
+
+func TestCompress_Discovery(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "one.pdf", "vendor/two.pdf", ".cache/three.pdf", "deep/er/four.pdf")
+	if err := os.WriteFile(filepath.Join(s.wdir, ".pressgoignore"), []byte("vendor/\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := run(t, s, compressCmd, "-init", "-max-depth", "2", "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := mustManifest(t, filepath.Join(s.wdir, configFile))
+	if len(manifest) != 1 || filepath.Base(manifest[0].Filename) != "one.pdf" {
+		t.Errorf("got manifest %+v", manifest)
+	}
+
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "one.pdf", NewName: "one-c.pdf"},
+		{Filename: "vendor/two.pdf", NewName: "two-c.pdf"},
+	})
+	if err := run(t, s, compressCmd, "-backend", "local", "-replace-if-smaller", "-exclude", "one.pdf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertExists(t, filepath.Join(s.wdir, "one.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "vendor", "two.pdf"), true)
+}
+
+func TestCompress_NotAPDF(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "real.pdf", "scan")
+	if err := os.WriteFile(filepath.Join(s.wdir, "fake.pdf"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-init", "-extensionless", "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := mustManifest(t, filepath.Join(s.wdir, configFile))
+	if len(manifest) != 2 || manifest[0].NewName != "real.pdf" || manifest[1].NewName != "scan.pdf" {
+		t.Errorf("got manifest %+v", manifest)
+	}
+
+	manifest = append(manifest, PDFsConfig{Filename: "fake.pdf", NewName: "fake-c.pdf", CompressionLevel: levelRecommended})
+	writeManifest(t, filepath.Join(s.wdir, configFile), manifest)
+	if err := run(t, s, compressCmd); err == nil {
+		t.Fatal("expected the fake pdf to be reported")
+	}
+	assertExists(t, filepath.Join(s.wdir, "scan.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "fake.pdf"), true)
+	if got := srv.Credits("key-1"); got != 8 {
+		t.Errorf("got %d credits left, want 8", got)
+	}
+}
+
+func TestCompress_SkipSmallAndCompressed(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "small.pdf")
+	if err := os.WriteFile(filepath.Join(s.wdir, "Big.pdf"), []byte(pdfWithPages(20)), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	minSize := fmt.Sprint(len(testPDF) + 1)
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-init", "-min-size", minSize, "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manifest := mustManifest(t, filepath.Join(s.wdir, configFile)); len(manifest) != 1 || manifest[0].NewName != "big.pdf" {
+		t.Fatalf("got manifest %+v", manifest)
+	}
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "big.pdf", NewName: "again.pdf"},
+		{Filename: "small.pdf", NewName: "small-c.pdf"},
+	})
+	if err := run(t, s, compressCmd, "-min-size", minSize, "-report", "report.json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := srv.Credits("key-1"); got != 9 {
+		t.Errorf("got %d credits left, want 9", got)
+	}
+	if rep := readReport(t, filepath.Join(s.wdir, "report.json")); rep.Totals.Skipped != 2 {
+		t.Errorf("got totals %+v", rep.Totals)
+	}
+	assertExists(t, filepath.Join(s.wdir, "again.pdf"), false)
+
+	if err := os.Remove(filepath.Join(s.wdir, configFile)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := run(t, s, compressCmd, "-init", "Author", "base"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manifest := mustManifest(t, filepath.Join(s.wdir, configFile)); len(manifest) != 1 || manifest[0].NewName != "small.pdf" {
+		t.Errorf("got manifest %+v", manifest)
+	}
+}
+
+func TestCompress_UnreadablePDF(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	writePDFs(t, s.wdir, "good.pdf")
+	// A directory named like a pdf can be stat'ed but not read.
+	if err := os.Mkdir(filepath.Join(s.wdir, "bad.pdf"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "bad.pdf", NewName: "bad-c.pdf"},
+		{Filename: "good.pdf", NewName: "good-c.pdf"},
+	})
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	run(t, s, compressCmd, "-report", "report.json")
+
+	assertExists(t, filepath.Join(s.wdir, "good-c.pdf"), true)
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	if rep.Totals.Failed != 1 || rep.Totals.Compressed != 1 {
+		t.Errorf("got totals %+v", rep.Totals)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"100":    100,
+		"40KB":   40 * 1024,
+		"1.5 mb": 1536 * 1024,
+		"2GB":    2 << 30,
+		"7b":     7,
+	}
+	for value, want := range tests {
+		got, err := parseSize(value)
+		if err != nil || got != want {
+			t.Errorf("got %d, %v for %q, want %d", got, err, value, want)
+		}
+	}
+
+	for _, value := range []string{"", "KB", "-1", "ten"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+// pageTransform makes the fake API merge files into a pdf with all their
+// pages, recording the page counts of the inputs in order.
+func pageTransform(t *testing.T, order *[]int) func(string, [][]byte, map[string]any) []byte {
+	return func(tool string, files [][]byte, _ map[string]any) []byte {
+		if tool != toolMerge {
+			return files[0]
+		}
+		total := 0
+		for _, file := range files {
+			n, err := pdfdoc.Verify(file)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			*order = append(*order, n)
+			total += n
+		}
+		return []byte(pdfWithPages(total))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	var order []int
+	srv.Transform = pageTransform(t, &order)
+	s := newTestState(t, srv)
+	for i, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(pdfWithPages(i+1)), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, mergeCmd, "-name", "Case 12 Full", "-author", "Me", "c.pdf", "a.pdf", "b.pdf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.wdir, "case-12-full.pdf"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := pdfdoc.Verify(data); err != nil || n != 6 {
+		t.Errorf("got %d pages, %v, want 6", n, err)
+	}
+	if !slices.Equal(order, []int{3, 1, 2}) {
+		t.Errorf("got pdfs merged in order %v, want [3 1 2]", order)
+	}
+	meta, _ := srv.Processed()[0]["metas"].(map[string]any)
+	if meta["Title"] != "Case 12 Full" || meta["Author"] != "Me" {
+		t.Errorf("got metas %v", meta)
+	}
+	assertExists(t, filepath.Join(s.wdir, "a.pdf"), true)
+	if got := srv.Credits("key-1"); got != 7 {
+		t.Errorf("got %d credits left, want 7", got)
+	}
+
+	if err := run(t, s, mergeCmd, "-name", "Case 12 Full", "a.pdf", "b.pdf"); err == nil {
+		t.Error("expected an error for an existing output")
+	}
+}
+
+func TestMerge_Manifest(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	var order []int
+	srv.Transform = pageTransform(t, &order)
+	s := newTestState(t, srv)
+	for i, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(pdfWithPages(i+1)), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "a.pdf", Order: 2},
+		{Filename: "b.pdf"},
+		{Filename: "c.pdf", Order: 1},
+	})
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, mergeCmd, "-compress", "-out", "out"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "out", mergeName+pdfExt), true)
+	if !slices.Equal(order, []int{3, 1}) {
+		t.Errorf("got pdfs merged in order %v, want [3 1]", order)
+	}
+	if got := len(srv.Processed()); got != 2 {
+		t.Fatalf("got %d processed tasks, want 2", got)
+	}
+	// The merged temp file is compressed under the name of the output.
+	file, _ := srv.Processed()[1]["files"].([]any)[0].(map[string]any)
+	if file["filename"] != mergeName+pdfExt {
+		t.Errorf("got filename %v, want %s", file["filename"], mergeName+pdfExt)
+	}
+	if got := srv.Credits("key-1"); got != 7 {
+		t.Errorf("got %d credits left, want 7", got)
+	}
+}
+
+// splitTransform makes the fake API split a pdf like the split tool: a zip
+// with one pdf per range, or a single pdf.
+func splitTransform(t *testing.T) func(string, [][]byte, map[string]any) []byte {
+	return func(tool string, files [][]byte, params map[string]any) []byte {
+		pages, err := pdfdoc.Verify(files[0])
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		var ranges []pageRange
+		if params["split_mode"] == "fixed_range" {
+			ranges = everyRanges(int(params["fixed_range"].(float64)), pages)
+		} else if ranges, err = parseRanges(params["ranges"].(string), pages); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if params["merge_after"] == true {
+			total := 0
+			for _, r := range ranges {
+				total += r.pages()
+			}
+			return []byte(pdfWithPages(total))
+		}
+		if len(ranges) == 1 {
+			return []byte(pdfWithPages(ranges[0].pages()))
+		}
+
+		// The archive isn't in page order, the names say which range each
+		// pdf is.
+		entries := map[string]string{}
+		for _, r := range ranges {
+			entries["upload-"+r.String()+pdfExt] = pdfWithPages(r.pages())
+		}
+		return zipOf(t, entries, true)
+	}
+}
+
+// zipOf returns a zip archive with the given files, sorted by name and in
+// reverse when reversed is set.
+func zipOf(t *testing.T, files map[string]string, reversed bool) []byte {
+	t.Helper()
+	names := slices.Sorted(maps.Keys(files))
+	if reversed {
+		slices.Reverse(names)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		f.Write([]byte(files[name]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackOutputs(t *testing.T) {
+	dir := t.TempDir()
+	outputs := []splitOutput{
+		{path: filepath.Join(dir, "a-1.pdf"), pages: 1, rangeName: "1"},
+		{path: filepath.Join(dir, "a-2.pdf"), pages: 1, rangeName: "2"},
+		{path: filepath.Join(dir, "a-3-4.pdf"), pages: 2, rangeName: "3-4"},
+	}
+	tagged := func(tag string, pages int) string {
+		return pdfWithPages(pages) + "%" + tag + "\n"
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		fails bool
+	}{
+		{"matched by name", map[string]string{"doc-2024-1.pdf": tagged("1", 1), "doc-2024-2.pdf": tagged("2", 1), "doc-2024-3-4.pdf": tagged("3-4", 2)}, false},
+		{"unknown range", map[string]string{"doc-1.pdf": tagged("new", 1), "doc-2.pdf": tagged("new", 1), "doc-5.pdf": tagged("new", 1)}, true},
+		{"missing range", map[string]string{"doc-1.pdf": tagged("new", 1), "doc-3-4.pdf": tagged("new", 2)}, true},
+		{"repeated range", map[string]string{"doc-1.pdf": tagged("new", 1), "doc_1.pdf": tagged("new", 1), "doc-3-4.pdf": tagged("new", 2)}, true},
+		{"wrong pages", map[string]string{"doc-1.pdf": tagged("new", 1), "doc-2.pdf": tagged("new", 1), "doc-3-4.pdf": tagged("new", 1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			download := filepath.Join(dir, "download.zip")
+			if err := os.WriteFile(download, zipOf(t, tt.files, true), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// A failed unpack leaves the outputs of the first case as they
+			// were.
+			err := unpackOutputs(download, outputs)
+			if tt.fails && err == nil {
+				t.Error("expected an error")
+			}
+			if !tt.fails && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, o := range outputs {
+				assertExists(t, o.path+partExt, false)
+				data, err := os.ReadFile(o.path)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.HasSuffix(string(data), "%"+o.rangeName+"\n") {
+					t.Errorf("got the wrong pdf for %s", o.path)
+				}
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		args []string
+		want map[string]int
+	}{
+		{[]string{"-ranges", "1-3,7,9-"}, map[string]int{"bundled-invoices-1-3.pdf": 3, "bundled-invoices-7.pdf": 1, "bundled-invoices-9-10.pdf": 2}},
+		{[]string{"-every", "4"}, map[string]int{"bundled-invoices-1-4.pdf": 4, "bundled-invoices-5-8.pdf": 4, "bundled-invoices-9-10.pdf": 2}},
+		{[]string{"-extract", "2,5-7"}, map[string]int{"bundled-invoices-2_5-7.pdf": 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args[0], func(t *testing.T) {
+			srv := fakeapi.New()
+			defer srv.Close()
+			srv.AddKey("key-1", 10)
+			srv.Transform = splitTransform(t)
+			s := newTestState(t, srv)
+			if err := os.WriteFile(filepath.Join(s.wdir, "Bundled Invoices.pdf"), []byte(pdfWithPages(10)), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			run(t, s, credentialsCmd, "-add", "me", "key-1")
+			args := append(slices.Clone(tt.args), "-out", "split", "Bundled Invoices.pdf")
+			if err := run(t, s, splitCmd, args...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(s.wdir, "split"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("got %d files, want %d", len(entries), len(tt.want))
+			}
+			for name, pages := range tt.want {
+				data, err := os.ReadFile(filepath.Join(s.wdir, "split", name))
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					continue
+				}
+				if n, err := pdfdoc.Verify(data); err != nil || n != pages {
+					t.Errorf("got %d pages, %v for %s, want %d", n, err, name, pages)
+				}
+			}
+			assertExists(t, filepath.Join(s.wdir, "Bundled Invoices.pdf"), true)
+
+			if err := run(t, s, splitCmd, args...); err == nil {
+				t.Error("expected an error for the existing outputs")
+			}
+		})
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	got, err := parseRanges("1-3, 7,10-", 12)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []pageRange{{1, 3}, {7, 7}, {10, 12}}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"", "0-2", "3-1", "1-13", "a", "1-b"} {
+		if _, err := parseRanges(spec, 12); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+
+	if got, want := everyRanges(5, 12), []pageRange{{1, 5}, {6, 10}, {11, 12}}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	stamps := map[int]map[string]any{}
+	srv.Transform = func(tool string, files [][]byte, params map[string]any) []byte {
+		if tool != toolWatermark {
+			t.Errorf("got tool %q, want %q", tool, toolWatermark)
+		}
+		n, err := pdfdoc.Verify(files[0])
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		stamps[n] = params
+		return files[0]
+	}
+	s := newTestState(t, srv)
+	for i, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(pdfWithPages(i+1)), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(s.wdir, "logo.png"), []byte("png"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "a.pdf", NewName: "a-draft.pdf"},
+		{Filename: "b.pdf", NewName: "b-logo.pdf", Watermark: &WatermarkConfig{Image: "logo.png", Position: "bottom-right", Opacity: 40, Pages: "2"}},
+		{Filename: "c.pdf", NewName: "c-bad.pdf", Watermark: &WatermarkConfig{Position: "left-top"}},
+	})
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, watermarkCmd, "-text", "DRAFT", "-rotation", "45", "-out", "out"); err == nil {
+		t.Error("expected an error for the invalid position")
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "out", "a-draft.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "out", "b-logo.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "out", "c-bad.pdf"), false)
+	if len(stamps) != 2 {
+		t.Fatalf("got %d stamped pdfs, want 2", len(stamps))
+	}
+
+	text := stamps[1]
+	if text["mode"] != "text" || text["text"] != "DRAFT" || text["rotation"] != float64(45) ||
+		text["vertical_position"] != "middle" || text["horizontal_position"] != "center" || text["pages"] != "all" {
+		t.Errorf("got text options %v", text)
+	}
+	image := stamps[2]
+	if image["mode"] != "image" || image["image"] == nil || image["text"] != nil || image["transparency"] != float64(40) ||
+		image["vertical_position"] != "bottom" || image["horizontal_position"] != "right" || image["pages"] != "2" {
+		t.Errorf("got image options %v", image)
+	}
+	if got := srv.Credits("key-1"); got != 8 {
+		t.Errorf("got %d credits left, want 8", got)
+	}
+}
+
+func TestWatermark_SameFile(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	s := newTestState(t, srv)
+	original := pdfWithPages(1)
+	if err := os.WriteFile(filepath.Join(s.wdir, "a.pdf"), []byte(original), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{{Filename: "a.pdf", NewName: "a.pdf"}})
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, watermarkCmd, "-text", "DRAFT", "-overwrite"); err == nil {
+		t.Error("expected an error stamping the pdf over itself")
+	}
+	if got, _ := os.ReadFile(filepath.Join(s.wdir, "a.pdf")); string(got) != original {
+		t.Error("expected the original to be left untouched")
+	}
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("got %d processed tasks, want 0", got)
+	}
+
+	if err := run(t, s, watermarkCmd, "-text", "DRAFT", "-out", "out"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertExists(t, filepath.Join(s.wdir, "out", "a.pdf"), true)
+}
+
+// encryptedPDF is a pdf with n pages whose trailer says it is encrypted.
+func encryptedPDF(n int) string {
+	return strings.Replace(pdfWithPages(n), "/Root 1 0 R", "/Root 1 0 R /Encrypt 1 0 R", 1)
+}
+
+// passwordTransform makes the fake API protect and unlock pdfs. Unlocking
+// with a password other than password returns garbage.
+func passwordTransform(password string) func(string, [][]byte, map[string]any) []byte {
+	return func(tool string, files [][]byte, params map[string]any) []byte {
+		switch tool {
+		case toolProtect:
+			return []byte(strings.Replace(string(files[0]), "/Root 1 0 R", "/Root 1 0 R /Encrypt 1 0 R", 1))
+		case toolUnlock:
+			file, _ := params["files"].([]any)[0].(map[string]any)
+			if file["password"] != password {
+				return []byte("wrong password")
+			}
+			return []byte(strings.Replace(string(files[0]), " /Encrypt 1 0 R", "", 1))
+		}
+		return files[0]
+	}
+}
+
+func TestProtectUnlock(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = passwordTransform("secret")
+	s := newTestState(t, srv)
+	if err := os.WriteFile(filepath.Join(s.wdir, "Contract.pdf"), []byte(pdfWithPages(2)), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, protectCmd, "-out", "locked", "Contract.pdf"); err == nil {
+		t.Error("expected an error for the missing password")
+	}
+	if err := run(t, s, protectCmd, "-password", "secret", "-out", "locked", "Contract.pdf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	locked := filepath.Join(s.wdir, "locked", "contract.pdf")
+	if encrypted, err := pdfs.Encrypted(locked); err != nil || !encrypted {
+		t.Errorf("got encrypted %v, %v, want true", encrypted, err)
+	}
+	if got := len(srv.Processed()); got != 1 {
+		t.Errorf("got %d processed tasks, want 1", got)
+	}
+	if got := srv.Processed()[0]["password"]; got != "secret" {
+		t.Errorf("got password %v, want secret", got)
+	}
+
+	if err := run(t, s, unlockCmd, "-password", "wrong", "-out", "open", locked); err == nil {
+		t.Error("expected an error for the wrong password")
+	}
+	assertExists(t, filepath.Join(s.wdir, "open", "contract.pdf"), false)
+
+	t.Setenv(passwordEnv, "secret")
+	if err := run(t, s, unlockCmd, "-out", "open", locked); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if encrypted, err := pdfs.Encrypted(filepath.Join(s.wdir, "open", "contract.pdf")); err != nil || encrypted {
+		t.Errorf("got encrypted %v, %v, want false", encrypted, err)
+	}
+
+	// Pdfs that are already as asked cost nothing.
+	if err := run(t, s, unlockCmd, "-out", "again", "Contract.pdf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertExists(t, filepath.Join(s.wdir, "again"), false)
+	if got := srv.Credits("key-1"); got != 7 {
+		t.Errorf("got %d credits left, want 7", got)
+	}
+}
+
+func TestProtect_SameFile(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = passwordTransform("secret")
+	s := newTestState(t, srv)
+	original := pdfWithPages(1)
+	if err := os.WriteFile(filepath.Join(s.wdir, "contract.pdf"), []byte(original), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, protectCmd, "-password", "secret", "-overwrite", "contract.pdf"); err == nil {
+		t.Error("expected an error protecting the pdf over itself")
+	}
+	if got, _ := os.ReadFile(filepath.Join(s.wdir, "contract.pdf")); string(got) != original {
+		t.Error("expected the original to be left untouched")
+	}
+	if got := len(srv.Processed()); got != 0 {
+		t.Errorf("got %d processed tasks, want 0", got)
+	}
+}
+
+func TestCompress_Encrypted(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = passwordTransform("secret")
+	s := newTestState(t, srv)
+	for name, content := range map[string]string{
+		"plain.pdf":   pdfWithPages(1),
+		"locked.pdf":  encryptedPDF(2),
+		"nopass.pdf":  encryptedPDF(3),
+		"envpass.pdf": encryptedPDF(4),
+	} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+		{Filename: "plain.pdf", NewName: "plain-out.pdf"},
+		{Filename: "locked.pdf", NewName: "locked-out.pdf", Password: "secret"},
+		{Filename: "nopass.pdf", NewName: "nopass-out.pdf"},
+	})
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, compressCmd, "-report", "report.json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertExists(t, filepath.Join(s.wdir, "plain-out.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "nopass.pdf"), true)
+	assertExists(t, filepath.Join(s.wdir, "nopass-out.pdf"), false)
+	if encrypted, err := pdfs.Encrypted(filepath.Join(s.wdir, "locked-out.pdf")); err != nil || encrypted {
+		t.Errorf("got encrypted %v, %v, want false", encrypted, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(s.wdir, "*"+partExt)); len(matches) > 0 {
+		t.Errorf("got leftover part files %v", matches)
+	}
+
+	rep := readReport(t, filepath.Join(s.wdir, "report.json"))
+	statuses := map[string]reportEntry{}
+	for _, e := range rep.Files {
+		statuses[e.OriginalPath] = e
+	}
+	if e := statuses["locked.pdf"]; e.Status != statusCompressed || e.Credits != 2 {
+		t.Errorf("got %q with %d credits for the locked pdf, want %q with 2", e.Status, e.Credits, statusCompressed)
+	}
+	if e := statuses["nopass.pdf"]; e.Status != statusSkipped || !strings.Contains(e.Error, passwordEnv) {
+		t.Errorf("got %q, %q for the pdf without password", e.Status, e.Error)
+	}
+	if got := srv.Credits("key-1"); got != 7 {
+		t.Errorf("got %d credits left, want 7", got)
+	}
+
+	// The password of the environment is used for the pdfs without one.
+	writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{{Filename: "envpass.pdf", NewName: "envpass-out.pdf"}})
+	t.Setenv(passwordEnv, "wrong")
+	if err := run(t, s, compressCmd); err == nil {
+		t.Error("expected an error for the wrong password")
+	}
+	assertExists(t, filepath.Join(s.wdir, "envpass.pdf"), true)
+
+	os.Remove(filepath.Join(s.wdir, journalFile))
+	t.Setenv(passwordEnv, "secret")
+	if err := run(t, s, compressCmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertExists(t, filepath.Join(s.wdir, "envpass-out.pdf"), true)
+}
+
+const (
+	testJPG = "\xff\xd8\xff\xe0 jfif"
+	testPNG = "\x89PNG\r\n\x1a\n data"
+)
+
+// imageTransform makes the fake API convert images to pdfs, one page each,
+// and pdfs to a jpg per page, zipped when there is more than one.
+func imageTransform(t *testing.T) func(string, [][]byte, map[string]any) []byte {
+	return func(tool string, files [][]byte, params map[string]any) []byte {
+		if tool == toolImagePDF {
+			if params["merge_after"] != true && len(files) > 1 {
+				t.Errorf("got %d images without merge_after", len(files))
+			}
+			return []byte(pdfWithPages(len(files)))
+		}
+
+		pages, err := pdfdoc.Verify(files[0])
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if pages == 1 {
+			return []byte(testJPG)
+		}
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for i := range pages {
+			f, _ := w.Create(fmt.Sprintf("page-%04d.jpg", i+1))
+			f.Write([]byte(testJPG))
+		}
+		w.Close()
+		return buf.Bytes()
+	}
+}
+
+func TestImagePDF(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = imageTransform(t)
+	s := newTestState(t, srv)
+	for name, content := range map[string]string{
+		"Receipt Photo.jpg": testJPG,
+		"receipt photo.png": testPNG,
+		"fake.png":          "not an image",
+		"notes.txt":         "notes",
+	} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, imagePDFCmd, "-orientation", "sideways"); err == nil {
+		t.Error("expected an error for the invalid orientation")
+	}
+	if err := run(t, s, imagePDFCmd, "-page-size", "A4", "-margin", "20", "-out", "pdfs"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Images that would share a name are numbered like compress does.
+	for _, name := range []string{"receipt-photo.pdf", "receipt-photo-2.pdf"} {
+		data, err := os.ReadFile(filepath.Join(s.wdir, "pdfs", name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n, err := pdfdoc.Verify(data); err != nil || n != 1 {
+			t.Errorf("got %d pages, %v for %s, want 1", n, err, name)
+		}
+	}
+	body := srv.Processed()[0]
+	if body["pagesize"] != "A4" || body["margin"] != float64(20) || body["orientation"] != "portrait" {
+		t.Errorf("got options %v", body)
+	}
+
+	if err := run(t, s, imagePDFCmd, "-merge", "-name", "All Receipts", "Receipt Photo.jpg", "receipt photo.png"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.wdir, "all-receipts.pdf"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := pdfdoc.Verify(data); err != nil || n != 2 {
+		t.Errorf("got %d pages, %v, want 2", n, err)
+	}
+	if got := srv.Credits("key-1"); got != 6 {
+		t.Errorf("got %d credits left, want 6", got)
+	}
+
+	if err := run(t, s, imagePDFCmd, "fake.png"); err == nil {
+		t.Error("expected an error for a file that is not an image")
+	}
+}
+
+func TestUnpackImages(t *testing.T) {
+	dir := t.TempDir()
+	// Stored out of order, and sorting the names as text would put page 10
+	// before page 2.
+	entries := map[string]string{}
+	for _, page := range []int{1, 2, 10} {
+		entries[fmt.Sprintf("survey-%d.jpg", page)] = testJPG + strconv.Itoa(page)
+	}
+	download := filepath.Join(dir, "download.zip")
+	if err := os.WriteFile(download, zipOf(t, entries, true), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n, err := unpackImages(download, dir, "survey")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("got %d images, want 3", n)
+	}
+	for i, page := range []int{1, 2, 10} {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("survey-%d.jpg", i+1)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := testJPG + strconv.Itoa(page); string(data) != want {
+			t.Errorf("survey-%d.jpg is not page %d", i+1, page)
+		}
+	}
+
+	entries["cover.jpg"] = testJPG
+	if err := os.WriteFile(download, zipOf(t, entries, true), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := unpackImages(download, t.TempDir(), "survey"); err == nil {
+		t.Error("expected an error for an image without a number")
+	}
+}
+
+func TestPDFJPG(t *testing.T) {
+	srv := fakeapi.New()
+	defer srv.Close()
+	srv.AddKey("key-1", 10)
+	srv.Transform = imageTransform(t)
+	s := newTestState(t, srv)
+	for name, pages := range map[string]int{"Site Survey.pdf": 3, "cover.pdf": 1} {
+		if err := os.WriteFile(filepath.Join(s.wdir, name), []byte(pdfWithPages(pages)), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	run(t, s, credentialsCmd, "-add", "me", "key-1")
+	if err := run(t, s, pdfJPGCmd, "-mode", "extract", "-out", "jpgs"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for folder, images := range map[string]int{"site-survey": 3, "cover": 1} {
+		entries, err := os.ReadDir(filepath.Join(s.wdir, "jpgs", folder))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) != images {
+			t.Errorf("got %d images in %s, want %d", len(entries), folder, images)
+		}
+		assertExists(t, filepath.Join(s.wdir, "jpgs", folder, fmt.Sprintf("%s-%d.jpg", folder, images)), true)
+	}
+	if got := srv.Processed()[0]["pdfjpg_mode"]; got != "extract" {
+		t.Errorf("got mode %v, want extract", got)
+	}
+
+	if err := run(t, s, pdfJPGCmd, "-out", "jpgs", "cover.pdf"); err == nil {
+		t.Error("expected an error for the folder with files")
+	}
+	if err := run(t, s, pdfJPGCmd, "-out", "jpgs", "-overwrite", "cover.pdf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := srv.Credits("key-1"); got != 7 {
+		t.Errorf("got %d credits left, want 7", got)
+	}
+}
+
+func TestCompress_EncryptedResume(t *testing.T) {
+	for _, state := range []journal.State{journal.Pending, journal.Uploaded} {
+		t.Run(string(state), func(t *testing.T) {
+			srv := fakeapi.New()
+			defer srv.Close()
+			srv.AddKey("key-1", 10)
+			srv.Transform = passwordTransform("secret")
+			s := newTestState(t, srv)
+			if err := os.WriteFile(filepath.Join(s.wdir, "locked.pdf"), []byte(encryptedPDF(2)), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			writeManifest(t, filepath.Join(s.wdir, configFile), []PDFsConfig{
+				{Filename: "locked.pdf", NewName: "locked-out.pdf", Password: "secret"},
+			})
+
+			// A run that unlocked the pdf, and maybe uploaded it with a
+			// credential that is gone, was interrupted.
+			unlocked := filepath.Join(s.wdir, "locked-out-unlocked.pdf.part")
+			if err := os.WriteFile(unlocked, []byte(pdfWithPages(2)), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			jr := journal.New(filepath.Join(s.wdir, journalFile))
+			entry := journal.Entry{Filename: "locked.pdf", NewName: "locked-out.pdf", State: state, Unlocked: unlocked}
+			if state == journal.Uploaded {
+				entry.CredentialID, entry.Server, entry.Task, entry.ServerFilename = "gone", srv.Host(), "task-gone", "file-gone.pdf"
+			}
+			if err := jr.Update(entry); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			run(t, s, credentialsCmd, "-add", "me", "key-1")
+			if err := run(t, s, compressCmd, "-resume"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if encrypted, err := pdfs.Encrypted(filepath.Join(s.wdir, "locked-out.pdf")); err != nil || encrypted {
+				t.Errorf("got encrypted %v, %v, want the unlocked copy compressed", encrypted, err)
+			}
+			for _, body := range srv.Processed() {
+				if body["tool"] == toolUnlock {
+					t.Error("expected the unlocked copy to be reused")
+				}
+				if body["tool"] == toolCompress {
+					file, _ := body["files"].([]any)[0].(map[string]any)
+					if file["filename"] != "locked.pdf" {
+						t.Errorf("got filename %v, want locked.pdf", file["filename"])
+					}
+				}
+			}
+			if got := srv.Credits("key-1"); got != 9 {
+				t.Errorf("got %d credits left, want 9", got)
+			}
+			assertExists(t, unlocked, false)
+		})
+	}
+}
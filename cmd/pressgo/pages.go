@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pageRange is a range of pages, both ends included and counted from 1.
+type pageRange struct {
+	from, to int
+}
+
+func (r pageRange) String() string {
+	if r.from == r.to {
+		return strconv.Itoa(r.from)
+	}
+	return fmt.Sprintf("%d-%d", r.from, r.to)
+}
+
+func (r pageRange) pages() int {
+	return r.to - r.from + 1
+}
+
+// parseRanges reads a list of ranges like 1-3,7,10- for a pdf with the given
+// pages. An open range runs to the last page.
+func parseRanges(spec string, pages int) ([]pageRange, error) {
+	var ranges []pageRange
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		end := start
+		if isRange {
+			end = pages
+			if to = strings.TrimSpace(to); to != "" {
+				if end, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid page range %q", part)
+				}
+			}
+		}
+
+		if start < 1 || end < start || end > pages {
+			return nil, fmt.Errorf("page range %q is out of the %d pages of the pdf", part, pages)
+		}
+		ranges = append(ranges, pageRange{start, end})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no pages in %q", spec)
+	}
+	return ranges, nil
+}
+
+// everyRanges splits pages in ranges of n, the last one with what is left.
+func everyRanges(n, pages int) []pageRange {
+	var ranges []pageRange
+	for from := 1; from <= pages; from += n {
+		ranges = append(ranges, pageRange{from, min(from+n-1, pages)})
+	}
+	return ranges
+}
+
+func joinRanges(ranges []pageRange, sep string) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared so piped passphrases aren't lost to a discarded buffer.
+var stdin = bufio.NewReader(os.Stdin)
+
+func promptPassphrase() (string, error) {
+	return readPassphrase("Passphrase: ")
+}
+
+// promptNewPassphrase asks for the passphrase twice so a typo doesn't lock
+// the credentials for good.
+func promptNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != confirmation {
+		return "", fmt.Errorf("The passphrases don't match")
+	}
+
+	return passphrase, nil
+}
+
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(passphrase), nil
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fernando8franco/pressgo/internal/cache"
+	"github.com/fernando8franco/pressgo/internal/config"
+	"github.com/fernando8franco/pressgo/internal/journal"
+	"github.com/fernando8franco/pressgo/pkg/pdfdoc"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+	"github.com/fernando8franco/pressgo/pkg/slug"
+	"golang.org/x/sync/errgroup"
+)
+
+type compressOptions struct {
+	// keepOriginals leaves the original pdfs in place next to the outputs.
+	keepOriginals bool
+	// replaceIfSmaller keeps the original, under the new name, whenever the
+	// compressed file isn't smaller, instead of reporting an error.
+	replaceIfSmaller bool
+	// workers is how many pdfs are compressed at the same time.
+	workers int
+	// overwrite replaces a file that already has the new name of a pdf,
+	// instead of refusing to compress it.
+	overwrite bool
+	// out is the directory the outputs are written to, the working directory
+	// when empty.
+	out string
+	// mirror writes each output under the directory of its original,
+	// relative to the working directory, inside out.
+	mirror bool
+	// slugDirs slugifies the mirrored directory names.
+	slugDirs bool
+	// minSize skips the pdfs smaller than it, in bytes.
+	minSize int64
+	// cache records the outputs, so pdfs pressgo already wrote are skipped.
+	// No cache is used when nil.
+	cache *cache.Cache
+	// unlock decrypts the encrypted pdfs with their password before
+	// compressing them. Without it they are skipped.
+	unlock bool
+}
+
+// compressPDFs compresses the pdfs with a pool of workers, adding the outcome
+// of each one to rep.
+func compressPDFs(ctx context.Context, s *state, b backend, jr *journal.Journal, pdfs []PDFsConfig, opts compressOptions, rep *runReport) error {
+	pdfsChannel := make(chan PDFsConfig)
+	wg, ctx := errgroup.WithContext(ctx)
+	for range max(1, opts.workers) {
+		wg.Go(func() error {
+			for pdf := range pdfsChannel {
+				fmt.Println("Compressing:", pdf.Filename)
+
+				entry := reportEntry{OriginalPath: pdf.Filename, NewName: pdf.NewName}
+				start := time.Now()
+				err := compressPDF(ctx, s, b, jr, pdf, opts, &entry)
+				entry.Duration = time.Since(start).Seconds()
+				if err != nil {
+					entry.Status = statusFailed
+					if ctx.Err() != nil {
+						entry.Status = statusInterrupted
+					}
+					entry.Error = err.Error()
+				}
+				rep.add(entry)
+
+				if errors.Is(err, config.ErrNoCredits) {
+					fmt.Println(pdf.Filename, "--- Error:", err)
+					return err
+				}
+				if err != nil {
+					fmt.Println(pdf.Filename, "--- Error:", err)
+					continue
+				}
+
+				if entry.Status == statusKeptOriginal {
+					fmt.Println(pdf.Filename, "--- Kept the original, the compressed file was not smaller")
+					continue
+				}
+				fmt.Println(pdf.Filename, "--- Compressed correctly")
+			}
+
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		defer close(pdfsChannel)
+		for _, pdf := range pdfs {
+			entry := jr.Get(pdf.Filename, pdf.NewName)
+			if entry.State == journal.Verified {
+				fmt.Println(pdf.Filename, "--- Already compressed")
+				rep.add(reportEntry{
+					OriginalPath: pdf.Filename,
+					NewName:      pdf.NewName,
+					Status:       statusAlreadyCompressed,
+					CredentialID: entry.CredentialID,
+				})
+				continue
+			}
+
+			// Once downloaded the original may already be gone.
+			if entry.State != journal.Downloaded {
+				if _, err := os.Stat(resolvePath(s, pdf.Filename)); errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+
+				// A pdf that can't be read fails alone, like one that can't be
+				// compressed.
+				reason, err := skipReason(s, pdf, opts)
+				if err != nil {
+					fmt.Println(pdf.Filename, "--- Error:", err)
+					rep.add(reportEntry{
+						OriginalPath: pdf.Filename,
+						NewName:      pdf.NewName,
+						Status:       statusFailed,
+						Error:        err.Error(),
+					})
+					continue
+				}
+				if reason != "" {
+					fmt.Println(pdf.Filename, "--- Skipped,", reason)
+					rep.add(reportEntry{
+						OriginalPath: pdf.Filename,
+						NewName:      pdf.NewName,
+						Status:       statusSkipped,
+						Error:        reason,
+					})
+					continue
+				}
+			}
+
+			select {
+			case pdfsChannel <- pdf:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return wg.Wait()
+}
+
+// skipReason returns why a pdf isn't worth compressing, or "" when it is:
+// it is smaller than opts.minSize, it is encrypted and can't be unlocked or
+// its content is an output of an earlier run.
+func skipReason(s *state, pdf PDFsConfig, opts compressOptions) (string, error) {
+	pdfFile := resolvePath(s, pdf.Filename)
+	info, err := os.Stat(pdfFile)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() < opts.minSize {
+		return fmt.Sprintf("it is smaller than %s", formatBytes(opts.minSize)), nil
+	}
+
+	encrypted, err := pdfs.Encrypted(pdfFile)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case encrypted && !opts.unlock:
+		return "it is encrypted and the backend can't unlock it", nil
+	case encrypted && pdfPassword(pdf) == "":
+		return fmt.Sprintf("it is encrypted, set its password in the config file or %s", passwordEnv), nil
+	}
+
+	if opts.cache == nil {
+		return "", nil
+	}
+	hash, _, err := cache.HashFile(pdfFile)
+	if err != nil {
+		return "", err
+	}
+	if e, ok := opts.cache.Get(hash); ok {
+		return fmt.Sprintf("it was already compressed as %s", e.NewName), nil
+	}
+	return "", nil
+}
+
+// remember adds the output to the cache, if there is one.
+func remember(output, newName string, c *cache.Cache) error {
+	if c == nil {
+		return nil
+	}
+
+	hash, size, err := cache.HashFile(output)
+	if err != nil {
+		return err
+	}
+	return c.Add(cache.Entry{Hash: hash, NewName: newName, Size: size})
+}
+
+// compressPDF has the backend write the compressed pdf next to its final
+// name, verifies it and only then swaps it in for the original. The sizes,
+// the credits used and whether the original was kept are recorded in e.
+func compressPDF(ctx context.Context, s *state, b backend, jr *journal.Journal, pdf PDFsConfig, opts compressOptions, e *reportEntry) error {
+	pdfFile := resolvePath(s, pdf.Filename)
+	compressPdfPath := outputPath(s, pdf, opts)
+	partPdfPath := compressPdfPath + partExt
+	if err := os.MkdirAll(filepath.Dir(compressPdfPath), 0755); err != nil {
+		return err
+	}
+
+	if opts.keepOriginals && pdfFile == compressPdfPath {
+		return fmt.Errorf("the original can't be kept, it has the same name as the compressed file")
+	}
+
+	entry := jr.Get(pdf.Filename, pdf.NewName)
+	// Before anything is paid for. Past that point the file may be an output
+	// of this same run.
+	if entry.State == journal.Pending || entry.State == journal.Uploaded {
+		if !opts.overwrite && overwrites(pdfFile, compressPdfPath) {
+			return fmt.Errorf("%s already exists, use -%s to replace it", pdf.NewName, overwriteFlag)
+		}
+		_, ok, err := pdfs.Sniff(pdfFile)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("the file is not a pdf")
+		}
+	}
+
+	// Encrypted pdfs are uploaded unlocked. The copy is journaled and kept
+	// until the output is downloaded, so a task started again from scratch,
+	// like with another credential, uploads it without paying to unlock the
+	// pdf twice.
+	src := pdfFile
+	if entry.State == journal.Pending || entry.State == journal.Uploaded {
+		encrypted, err := pdfs.Encrypted(pdfFile)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			if !hasUnlockedCopy(entry) {
+				unlocked := withNameSuffix(compressPdfPath, "unlocked") + partExt
+				if err := unlockPDF(ctx, b, pdf, pdfFile, unlocked, e); err != nil {
+					os.Remove(unlocked)
+					return err
+				}
+				entry.Unlocked = unlocked
+				if err := jr.Update(entry); err != nil {
+					return err
+				}
+			}
+			src = entry.Unlocked
+		}
+	}
+	if entry.State == journal.Downloaded {
+		if _, err := os.Stat(partPdfPath); err != nil {
+			entry.State = journal.Processed
+		}
+	}
+
+	if entry.State != journal.Downloaded {
+		u, err := b.compress(ctx, pdf, src, partPdfPath)
+		e.CredentialID = u.credentialID
+		e.Credits += u.credits
+		e.Retries += u.retries
+		if err != nil {
+			// Whatever was written is partial, the original stays as it was.
+			os.Remove(partPdfPath)
+			return err
+		}
+
+		entry = jr.Get(pdf.Filename, pdf.NewName)
+		entry.State = journal.Downloaded
+		if entry.Unlocked != "" {
+			os.Remove(entry.Unlocked)
+			entry.Unlocked = ""
+		}
+		if err := jr.Update(entry); err != nil {
+			return err
+		}
+	}
+
+	if e.CredentialID == "" {
+		e.CredentialID = entry.CredentialID
+	}
+
+	originalSize, compressedSize, err := verifyOutput(pdfFile, partPdfPath)
+	e.BytesBefore, e.BytesAfter = max(originalSize, 0), compressedSize
+	if err != nil {
+		// Downloading again is free and fixes a transfer that was cut short.
+		os.Remove(partPdfPath)
+		entry.State = journal.Processed
+		jr.Update(entry)
+		return err
+	}
+
+	e.Status = statusCompressed
+	output := compressPdfPath
+	switch {
+	case originalSize >= 0 && compressedSize >= originalSize && opts.replaceIfSmaller:
+		e.Status = statusKeptOriginal
+		e.BytesAfter = originalSize
+		if err := os.Remove(partPdfPath); err != nil {
+			return err
+		}
+		if opts.keepOriginals {
+			// Nothing was written, the original is what isn't worth sending again.
+			output = pdfFile
+		} else if err := os.Rename(pdfFile, compressPdfPath); err != nil {
+			return err
+		}
+	case originalSize >= 0 && compressedSize > originalSize:
+		// The output stays downloaded so a -resume with -replace-if-smaller
+		// doesn't have to pay for it again.
+		return fmt.Errorf("the compressed file (%d bytes) is larger than the original (%d bytes), use -%s to keep the original", compressedSize, originalSize, replaceIfSmallerFlag)
+	default:
+		// The rename replaces the output atomically, so the original is only
+		// removed once the output is in place, unless it was the output.
+		replaced := sameFile(pdfFile, compressPdfPath)
+		if err := os.Rename(partPdfPath, compressPdfPath); err != nil {
+			return err
+		}
+		if !opts.keepOriginals && !replaced {
+			if err := os.Remove(pdfFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	if err := remember(output, pdf.NewName, opts.cache); err != nil {
+		return err
+	}
+
+	entry.State = journal.Verified
+	return jr.Update(entry)
+}
+
+// hasUnlockedCopy reports whether the unlocked copy recorded in entry is
+// still there.
+func hasUnlockedCopy(entry journal.Entry) bool {
+	return entry.Unlocked != "" && exists(entry.Unlocked)
+}
+
+// unlockPDF writes to dst a copy of the encrypted pdfFile without its
+// password, adding what it cost to e.
+func unlockPDF(ctx context.Context, b backend, pdf PDFsConfig, pdfFile, dst string, e *reportEntry) error {
+	ul, ok := b.(unlocker)
+	if !ok {
+		return fmt.Errorf("the file is encrypted and the backend can't unlock it")
+	}
+	password := pdfPassword(pdf)
+	if password == "" {
+		return fmt.Errorf("the file is encrypted, set its password in the config file or %s", passwordEnv)
+	}
+
+	fmt.Println("Unlocking:", pdf.Filename)
+	u, err := ul.unlock(ctx, pdfFile, password, dst)
+	e.CredentialID, e.Credits, e.Retries = u.credentialID, u.credits, u.retries
+	if err != nil {
+		return fmt.Errorf("the file could not be unlocked: %w", err)
+	}
+	return nil
+}
+
+// verifyOutput checks that the compressed pdf is complete and has as many
+// pages as the original. It returns both sizes; the original's is -1 when
+// it is already gone.
+func verifyOutput(pdfFile, partPdfPath string) (int64, int64, error) {
+	compressed, err := os.ReadFile(partPdfPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	compressedPages, err := pdfdoc.Verify(compressed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the compressed file is not a valid pdf: %v", err)
+	}
+
+	original, err := os.ReadFile(pdfFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return -1, int64(len(compressed)), nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Originals pdfdoc can't read are only compared by size.
+	if originalPages, err := pdfdoc.Verify(original); err == nil && originalPages != compressedPages {
+		return 0, 0, fmt.Errorf("the compressed file has %d pages, the original has %d", compressedPages, originalPages)
+	}
+
+	return int64(len(original)), int64(len(compressed)), nil
+}
+
+// outputPath is where the compressed pdf ends up: its new name inside the
+// output directory and, when mirroring, inside the directory of the original
+// relative to the working directory. Originals outside of it aren't mirrored.
+func outputPath(s *state, pdf PDFsConfig, opts compressOptions) string {
+	dir := s.wdir
+	if opts.out != "" {
+		dir = resolvePath(s, opts.out)
+	}
+
+	if opts.mirror {
+		rel, err := filepath.Rel(s.wdir, filepath.Dir(resolvePath(s, pdf.Filename)))
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			if opts.slugDirs {
+				rel = slugDir(rel)
+			}
+			dir = filepath.Join(dir, rel)
+		}
+	}
+
+	return filepath.Join(dir, pdf.NewName)
+}
+
+// slugDir slugifies every element of a relative directory. Elements with
+// nothing left after that keep their name.
+func slugDir(dir string) string {
+	elems := strings.Split(dir, string(filepath.Separator))
+	for i, elem := range elems {
+		if slugged := slug.Create(elem); slugged != "" {
+			elems[i] = slugged
+		}
+	}
+	return filepath.Join(elems...)
+}
+
+// sameFile reports whether both paths are the same existing file, like names
+// that only differ in case on most file systems.
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// overwrites reports whether writing the output would replace a file other
+// than the original it comes from.
+func overwrites(pdfFile, compressPdfPath string) bool {
+	output, err := os.Stat(compressPdfPath)
+	if err != nil {
+		return false
+	}
+	original, err := os.Stat(pdfFile)
+	return err != nil || !os.SameFile(original, output)
+}
+
+func resolvePath(s *state, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(s.wdir, name)
+}
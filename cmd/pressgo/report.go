@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	statusCompressed        = "compressed"
+	statusKeptOriginal      = "kept original"
+	statusFailed            = "failed"
+	statusAlreadyCompressed = "already compressed"
+	statusInterrupted       = "interrupted"
+	statusSkipped           = "skipped"
+)
+
+type reportEntry struct {
+	OriginalPath string  `json:"original_path"`
+	NewName      string  `json:"new_name"`
+	Status       string  `json:"status"`
+	BytesBefore  int64   `json:"bytes_before"`
+	BytesAfter   int64   `json:"bytes_after"`
+	Ratio        float64 `json:"ratio"`
+	Duration     float64 `json:"duration_seconds"`
+	CredentialID string  `json:"credential_id"`
+	Credits      int     `json:"credits"`
+	Retries      int     `json:"retries"`
+	Error        string  `json:"error"`
+}
+
+type reportTotals struct {
+	Files             int     `json:"files"`
+	Compressed        int     `json:"compressed"`
+	KeptOriginal      int     `json:"kept_original"`
+	Failed            int     `json:"failed"`
+	AlreadyCompressed int     `json:"already_compressed"`
+	Interrupted       int     `json:"interrupted"`
+	Skipped           int     `json:"skipped"`
+	BytesBefore       int64   `json:"bytes_before"`
+	BytesAfter        int64   `json:"bytes_after"`
+	Ratio             float64 `json:"ratio"`
+	Duration          float64 `json:"duration_seconds"`
+	Credits           int     `json:"credits"`
+	Retries           int     `json:"retries"`
+}
+
+// runReport collects the outcome of every pdf of a compress run. Workers add
+// to it concurrently.
+type runReport struct {
+	mu      sync.Mutex
+	Files   []reportEntry `json:"files"`
+	Totals  reportTotals  `json:"totals"`
+	started time.Time
+}
+
+func newRunReport() *runReport {
+	return &runReport{Files: []reportEntry{}, started: time.Now()}
+}
+
+func (r *runReport) add(e reportEntry) {
+	if e.BytesBefore > 0 && e.BytesAfter > 0 {
+		e.Ratio = ratio(e.BytesBefore, e.BytesAfter)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Files = append(r.Files, e)
+
+	t := &r.Totals
+	t.Files++
+	t.Credits += e.Credits
+	t.Retries += e.Retries
+	switch e.Status {
+	case statusCompressed:
+		t.Compressed++
+	case statusKeptOriginal:
+		t.KeptOriginal++
+	case statusFailed:
+		t.Failed++
+	case statusAlreadyCompressed:
+		t.AlreadyCompressed++
+	case statusInterrupted:
+		t.Interrupted++
+	case statusSkipped:
+		t.Skipped++
+	}
+	if e.Status == statusCompressed || e.Status == statusKeptOriginal {
+		t.BytesBefore += e.BytesBefore
+		t.BytesAfter += e.BytesAfter
+	}
+}
+
+// finish computes the totals that depend on the whole run.
+func (r *runReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Totals.Duration = time.Since(r.started).Seconds()
+	if r.Totals.BytesBefore > 0 {
+		r.Totals.Ratio = ratio(r.Totals.BytesBefore, r.Totals.BytesAfter)
+	}
+}
+
+// ratio is the compressed size as a fraction of the original one.
+func ratio(before, after int64) float64 {
+	return float64(after) / float64(before)
+}
+
+func validReportPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".json" || ext == ".csv"
+}
+
+// write saves the report as json or csv, depending on the extension of path.
+func (r *runReport) write(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+
+	w := csv.NewWriter(file)
+	w.Write([]string{
+		"original_path", "new_name", "status", "bytes_before", "bytes_after", "ratio",
+		"duration_seconds", "credential_id", "credits", "retries", "error",
+	})
+	for _, e := range r.Files {
+		w.Write([]string{
+			e.OriginalPath,
+			e.NewName,
+			e.Status,
+			strconv.FormatInt(e.BytesBefore, 10),
+			strconv.FormatInt(e.BytesAfter, 10),
+			strconv.FormatFloat(e.Ratio, 'f', 4, 64),
+			strconv.FormatFloat(e.Duration, 'f', 3, 64),
+			e.CredentialID,
+			strconv.Itoa(e.Credits),
+			strconv.Itoa(e.Retries),
+			e.Error,
+		})
+	}
+
+	t := r.Totals
+	w.Write([]string{
+		"TOTAL",
+		fmt.Sprintf("%d files", t.Files),
+		fmt.Sprintf("%d compressed, %d kept original, %d failed", t.Compressed, t.KeptOriginal, t.Failed),
+		strconv.FormatInt(t.BytesBefore, 10),
+		strconv.FormatInt(t.BytesAfter, 10),
+		strconv.FormatFloat(t.Ratio, 'f', 4, 64),
+		strconv.FormatFloat(t.Duration, 'f', 3, 64),
+		"",
+		strconv.Itoa(t.Credits),
+		strconv.Itoa(t.Retries),
+		"",
+	})
+	w.Flush()
+
+	return w.Error()
+}
+
+func (r *runReport) printSummary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rows := [][]string{}
+	for _, e := range r.Files {
+		rows = append(rows, []string{
+			filepath.Base(e.OriginalPath),
+			e.NewName,
+			e.Status,
+			formatBytes(e.BytesBefore),
+			formatBytes(e.BytesAfter),
+			formatRatio(e.Ratio),
+			e.CredentialID,
+			strconv.Itoa(e.Credits),
+			strconv.Itoa(e.Retries),
+		})
+	}
+
+	t := r.Totals
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"File", "New Name", "Status", "Before", "After", "Ratio", "Credential", "Credits", "Retries"})
+	table.Bulk(rows)
+	table.Footer([]string{
+		"Total", fmt.Sprintf("%d files", t.Files), fmt.Sprintf("%d failed", t.Failed),
+		formatBytes(t.BytesBefore), formatBytes(t.BytesAfter), formatRatio(t.Ratio),
+		"", strconv.Itoa(t.Credits), strconv.Itoa(t.Retries),
+	})
+	table.Render()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n <= 0:
+		return "-"
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
+
+func formatRatio(r float64) string {
+	if r == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", r*100)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+	"github.com/fernando8franco/pressgo/internal/config"
+)
+
+// retryPolicy decides how often and how long to wait before an API step that
+// failed with a transient error is tried again.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// newRetryPolicy returns the built-in policy with the defaults of the global
+// config applied.
+func newRetryPolicy(settings config.Settings) retryPolicy {
+	p := retryPolicy{maxAttempts: defaultMaxAttempts, baseDelay: defaultRetryDelay}
+	if settings.MaxAttempts > 0 {
+		p.maxAttempts = settings.MaxAttempts
+	}
+	if d, err := time.ParseDuration(settings.RetryDelay); err == nil && d > 0 {
+		p.baseDelay = d
+	}
+	return p
+}
+
+func (p retryPolicy) validate() error {
+	if p.maxAttempts < 1 {
+		return fmt.Errorf("-%s must be greater than 0", maxAttemptsFlag)
+	}
+	if p.baseDelay <= 0 {
+		return fmt.Errorf("-%s must be a positive duration", retryDelayFlag)
+	}
+	return nil
+}
+
+// delay is the wait before the given retry, starting at 1: the base delay
+// doubled on every retry, capped, with up to half of it taken off at random
+// so workers that failed together don't retry together. A Retry-After sent
+// by the server wins over the backoff.
+func (p retryPolicy) delay(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, maxRetryAfter)
+	}
+
+	d := p.baseDelay << min(retry-1, 16)
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d - rand.N(d/2+1)
+}
+
+// isRetryable reports whether err is transient: timeouts, dropped
+// connections, rate limiting and server errors. Anything else, like a file
+// that can't be read or a request the API rejects, fails the same way again.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *iloveapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isRejected reports whether err means the API turned a request down before
+// acting on it: it was rate limited or the connection was never made. Only
+// then is a step that charges credits sent again, after any other failure
+// the task may already have been processed and paid for.
+func isRejected(err error) bool {
+	var apiErr *iloveapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+type retryAfterKey struct{}
+
+// retryAfterHint is filled in by retryAfterTransport with the Retry-After of
+// the last response of a request made with its context.
+type retryAfterHint struct {
+	delay time.Duration
+}
+
+// retryAfterTransport records the Retry-After header of rate limited and
+// unavailable responses, since the API client only reports the status.
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	hint, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHint)
+	if ok && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		hint.delay = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return resp, nil
+}
+
+// parseRetryAfter reads a Retry-After value, either seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now))
+	}
+	return 0
+}
+
+// withRetries runs step until it succeeds, fails with an error that isn't
+// transient or runs out of attempts. Every retry is counted in retries. The
+// process step, which charges credits, is only retried when it was rejected.
+func withRetries[T any](ctx context.Context, p retryPolicy, name string, retries *int, step func(ctx context.Context) (T, error)) (T, error) {
+	retryable := isRetryable
+	if name == stepProcess {
+		retryable = isRejected
+	}
+
+	for attempt := 1; ; attempt++ {
+		hint := &retryAfterHint{}
+		response, err := step(context.WithValue(ctx, retryAfterKey{}, hint))
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return response, err
+		}
+		if attempt >= p.maxAttempts {
+			return response, fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+		}
+
+		wait := p.delay(attempt, hint.delay)
+		fmt.Printf("The %s step failed (%v), retrying in %v\n", name, err, wait.Round(time.Millisecond))
+		*retries++
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return response, ctx.Err()
+		}
+	}
+}
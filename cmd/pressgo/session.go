@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+	"github.com/fernando8franco/pressgo/internal/config"
+)
+
+var errCreditsExhausted = errors.New("the credential has no credits left")
+
+// session holds the API client of the active credential and swaps it for the
+// next credential with credits when the current one runs out.
+type session struct {
+	s   *state
+	id  string
+	api *iloveapi.Client
+
+	// clients of other credentials, used to finish tasks they already started.
+	clients map[string]*iloveapi.Client
+}
+
+func newSession(ctx context.Context, s *state) (*session, error) {
+	id, credential, err := s.cfg.GetActiveCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := storedAPIClient(ctx, s, id, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{
+		s:       s,
+		id:      id,
+		api:     api,
+		clients: map[string]*iloveapi.Client{id: api},
+	}, nil
+}
+
+// storedAPIClient returns a client that uses the saved token of the
+// credential, generating one only when there is none yet. An expired token
+// is refreshed by callWithRetry the first time the API rejects it.
+func storedAPIClient(ctx context.Context, s *state, id string, credential config.Credential) (*iloveapi.Client, error) {
+	if credential.Token == "" {
+		return newAPIClient(ctx, s, id, credential.Key)
+	}
+
+	api := iloveapi.NewClient(s.client)
+	api.SetToken(credential.Token)
+	return api, nil
+}
+
+// newAPIClient returns a client with a newly generated token, which is saved
+// in the config.
+func newAPIClient(ctx context.Context, s *state, id, key string) (*iloveapi.Client, error) {
+	api := iloveapi.NewClient(s.client)
+	if err := api.GenerateToken(ctx, key); err != nil {
+		return nil, err
+	}
+
+	if err := s.cfg.SetToken(id, api.GetToken()); err != nil {
+		return nil, err
+	}
+
+	return api, nil
+}
+
+func (ss *session) current() (string, *iloveapi.Client) {
+	ss.s.mu.RLock()
+	defer ss.s.mu.RUnlock()
+	return ss.id, ss.api
+}
+
+func (ss *session) setCredits(id string, credits int) error {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+	return ss.s.cfg.SetCredits(id, credits)
+}
+
+// failover switches away from the exhausted credential. Workers that hit the
+// same exhausted credential concurrently only switch once.
+func (ss *session) failover(ctx context.Context, exhaustedID string) error {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+
+	if ss.id != exhaustedID {
+		return nil
+	}
+
+	next, err := ss.s.cfg.ExhaustCredential(exhaustedID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The credential with id: %v ran out of credits, switching to: %v\n", exhaustedID, next)
+
+	api, err := newAPIClient(ctx, ss.s, next, ss.s.cfg.Credentials[next].Key)
+	if err != nil {
+		return err
+	}
+
+	ss.id = next
+	ss.api = api
+	ss.clients[next] = api
+	return nil
+}
+
+func (ss *session) clientFor(ctx context.Context, id string) (*iloveapi.Client, error) {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+
+	if api, ok := ss.clients[id]; ok {
+		return api, nil
+	}
+
+	credential, ok := ss.s.cfg.Credentials[id]
+	if !ok {
+		return nil, fmt.Errorf("The credential id doesn't exist")
+	}
+
+	api, err := storedAPIClient(ctx, ss.s, id, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.clients[id] = api
+	return api, nil
+}
+
+// refreshToken replaces stale, the client of credential id whose token was
+// rejected, with one holding a new token. Clients are never modified, since
+// other workers may be using them, so workers that hit the same expired
+// token concurrently share a single refresh: only the first one still finds
+// stale in use, the rest get its replacement.
+func (ss *session) refreshToken(ctx context.Context, id string, stale *iloveapi.Client) (*iloveapi.Client, error) {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+
+	if api, ok := ss.clients[id]; ok && api != stale {
+		return api, nil
+	}
+
+	credential, ok := ss.s.cfg.Credentials[id]
+	if !ok {
+		return nil, fmt.Errorf("The credential id doesn't exist")
+	}
+
+	fmt.Println("Refreshing the token of the credential with id:", id)
+	api, err := newAPIClient(ctx, ss.s, id, credential.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing the token: %v", err)
+	}
+
+	ss.clients[id] = api
+	if ss.id == id {
+		ss.api = api
+	}
+	return api, nil
+}
+
+func isUnauthorized(err error) bool {
+	type unauthorized interface{ IsUnauthorized() bool }
+	var u unauthorized
+	return errors.As(err, &u) && u.IsUnauthorized()
+}
+
+// callWithRetry runs call with *api, the client of credential id. When the
+// API rejects the token, *api is replaced by a client with a fresh token and
+// call runs once more.
+func callWithRetry[T any](ctx context.Context, ss *session, id string, api **iloveapi.Client, call func(context.Context, *iloveapi.Client) (T, error)) (T, error) {
+	response, err := call(ctx, *api)
+	if !isUnauthorized(err) {
+		return response, err
+	}
+
+	refreshed, err := ss.refreshToken(ctx, id, *api)
+	if err != nil {
+		return response, err
+	}
+	*api = refreshed
+
+	return call(ctx, refreshed)
+}
+
+func isQuotaExceeded(err error) bool {
+	if errors.Is(err, errCreditsExhausted) {
+		return true
+	}
+
+	type quotaExceeded interface{ IsQuotaExceeded() bool }
+	var q quotaExceeded
+	return errors.As(err, &q) && q.IsQuotaExceeded()
+}
+
+// withFailover runs f with the active credential, moving on to the next
+// credential and running f again whenever the current one runs out of credits.
+func withFailover(ctx context.Context, ss *session, f func(id string, api *iloveapi.Client) error) error {
+	for {
+		id, api := ss.current()
+		err := f(id, api)
+		if !isQuotaExceeded(err) {
+			return err
+		}
+
+		if err := ss.failover(ctx, id); err != nil {
+			if errors.Is(err, config.ErrNoCredits) {
+				return err
+			}
+			return fmt.Errorf("error switching credential: %v", err)
+		}
+	}
+}
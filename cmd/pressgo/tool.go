@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	iloveapi "github.com/fernando8franco/i-love-api-golang"
+	"github.com/fernando8franco/pressgo/pkg/pdfs"
+)
+
+// toolTask is a run of an iLovePDF tool over some files, in order, for the
+// commands other than compress. It isn't journaled: an interrupted task is
+// started again from scratch.
+type toolTask struct {
+	tool    string
+	files   []string
+	meta    iloveapi.Meta
+	options map[string]any
+	// passwords of the files, by index, for the ones that need one.
+	passwords map[int]string
+	// names the files are uploaded as, by index, for temp files whose name
+	// on disk isn't the one of the pdf.
+	names map[int]string
+	// uploads are other files the tool uses, like the image of a watermark,
+	// by the option their server filename is passed in.
+	uploads map[string]string
+}
+
+// runTool uploads the files of t into one task, processes it and downloads
+// the output to dst, switching credentials when the active one runs out of
+// credits. The tools charge one credit per file.
+func (b *ilovePDFBackend) runTool(ctx context.Context, t toolTask, dst string) (usage, error) {
+	var u usage
+	err := withFailover(ctx, b.ss, func(id string, api *iloveapi.Client) error {
+		u.credentialID = id
+
+		start, err := apiStep(ctx, b, stepStart, id, &api, &u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.StartResponse, error) {
+			return api.Start(ctx, iloveapi.StartParams{Tool: t.tool, Region: region})
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := b.ss.setCredits(id, start.RemainingCredits); err != nil {
+			return err
+		}
+		if start.RemainingCredits < len(t.files) {
+			return errCreditsExhausted
+		}
+
+		files := make([]iloveapi.File, len(t.files))
+		for i, path := range t.files {
+			name := t.names[i]
+			if name == "" {
+				name = filepath.Base(path)
+			}
+			upload, err := apiStep(ctx, b, stepUpload, id, &api, &u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.UploadResponse, error) {
+				return uploadFile(ctx, api, start, path, name)
+			})
+			if err != nil {
+				return err
+			}
+			files[i] = iloveapi.File{
+				ServerFilename: upload.ServerFilename,
+				Filename:       name,
+				Password:       t.passwords[i],
+			}
+		}
+
+		options := maps.Clone(t.options)
+		for _, option := range slices.Sorted(maps.Keys(t.uploads)) {
+			upload, err := apiStep(ctx, b, stepUpload, id, &api, &u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.UploadResponse, error) {
+				return uploadFile(ctx, api, start, t.uploads[option], filepath.Base(t.uploads[option]))
+			})
+			if err != nil {
+				return err
+			}
+			if options == nil {
+				options = map[string]any{}
+			}
+			options[option] = upload.ServerFilename
+		}
+
+		_, err = apiStep(ctx, b, stepProcess, id, &api, &u, func(ctx context.Context, api *iloveapi.Client) (iloveapi.ProcessResponse, error) {
+			return api.Process(ctx, iloveapi.ProcessParams{
+				Server:  start.Server,
+				Task:    start.Task,
+				Tool:    t.tool,
+				Files:   files,
+				Meta:    t.meta,
+				Options: options,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		u.credits += len(t.files)
+
+		_, err = apiStep(ctx, b, stepDownload, id, &api, &u, func(ctx context.Context, api *iloveapi.Client) (struct{}, error) {
+			return struct{}{}, downloadPDF(ctx, api, start.Server, start.Task, dst)
+		})
+		return err
+	})
+	return u, err
+}
+
+// toolPDFs returns the pdfs a tool command runs on: the files given, named
+// like -init would, or the ones of the config file when there are none. It
+// returns false when there are neither.
+func toolPDFs(s *state, files []string) ([]PDFsConfig, bool, error) {
+	if len(files) > 0 {
+		var pdfs []PDFsConfig
+		for _, file := range files {
+			pdfs = append(pdfs, newPDFConfig(file, manifestOptions{}))
+		}
+		return pdfs, true, nil
+	}
+
+	configFile := filepath.Join(s.wdir, configFile)
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	pdfs, err := getConfigPdfsFile(configFile)
+	return pdfs, err == nil, err
+}
+
+// discoverInputs returns the files a conversion command runs on, named like
+// -init would: the files given or, without them, the pdfs or images that
+// discovery finds in the working directory.
+func discoverInputs(s *state, files []string, opts pdfs.Options, images bool) ([]PDFsConfig, error) {
+	kind, find := "pdfs", pdfs.GetFromDir
+	if images {
+		kind, find = "images", pdfs.GetImagesFromDir
+	}
+
+	if len(files) == 0 {
+		found, invalid, err := find(s.wdir, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range invalid {
+			fmt.Println(path, "--- Skipped, its extension doesn't match its content")
+		}
+		for _, file := range found {
+			files = append(files, file.Path)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No %s found.", kind)
+	}
+
+	inputs := make([]PDFsConfig, 0, len(files))
+	for _, file := range files {
+		inputs = append(inputs, newPDFConfig(file, manifestOptions{}))
+	}
+	resolveCollisions(inputs, collisionSuffix)
+	return inputs, nil
+}
+
+func uploadFile(ctx context.Context, api *iloveapi.Client, start iloveapi.StartResponse, path, name string) (iloveapi.UploadResponse, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return iloveapi.UploadResponse{}, err
+	}
+	defer file.Close()
+
+	return api.Upload(ctx, iloveapi.UploadParams{
+		Server:   start.Server,
+		Task:     start.Task,
+		File:     file,
+		FileName: name,
+	})
+}
@@ -2,10 +2,18 @@ module github.com/fernando8franco/pressgo
 
 go 1.25.6
 
-require github.com/fernando8franco/i-love-api-golang v0.1.2
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/fernando8franco/i-love-api-golang v0.1.2
+	github.com/olekukonko/tablewriter v1.1.4
+	golang.org/x/sync v0.18.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
+	golang.org/x/time v0.14.0
+	gopkg.in/Regis24GmbH/go-diacritics.v2 v2.0.3
+)
 
 require (
-	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/clipperhouse/displaywidth v0.10.0 // indirect
 	github.com/clipperhouse/uax29/v2 v2.6.0 // indirect
 	github.com/fatih/color v1.18.0 // indirect
@@ -15,11 +23,7 @@ require (
 	github.com/olekukonko/cat v0.0.0-20250911104152-50322a0618f6 // indirect
 	github.com/olekukonko/errors v1.2.0 // indirect
 	github.com/olekukonko/ll v0.1.6 // indirect
-	github.com/olekukonko/tablewriter v1.1.4 // indirect
-	golang.org/x/sync v0.18.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
-	gopkg.in/Regis24GmbH/go-diacritics.v2 v2.0.3 // indirect
 )
 
 replace github.com/fernando8franco/i-love-api-golang => ../i-love-api-golang
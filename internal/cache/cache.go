@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type Entry struct {
+	Hash      string    `json:"hash"`
+	NewName   string    `json:"new_name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Cache remembers the content of the pdfs pressgo wrote, so a later run over
+// the same directory can tell its own outputs from the originals and doesn't
+// send them again.
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func Open(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: map[string]Entry{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		c.entries[e.Hash] = e
+	}
+
+	return c, nil
+}
+
+// Get returns the entry of the output with the given content hash.
+func (c *Cache) Get(hash string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[hash]
+	return e, ok
+}
+
+func (c *Cache) Add(e Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.CreatedAt = time.Now()
+	c.entries[e.Hash] = e
+	return c.write()
+}
+
+// write replaces the cache file atomically so a crash never leaves it half
+// written.
+func (c *Cache) write() error {
+	entries := slices.SortedFunc(maps.Values(c.entries), func(a, b Entry) int {
+		return strings.Compare(a.Hash, b.Hash)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, c.path)
+}
+
+// HashFile returns the xxhash of the content of the file at path, in hex,
+// and its size.
+func HashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := xxhash.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16), n, nil
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen_FileNotExist(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "cache.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := c.Get("abc"); ok {
+		t.Error("expected an empty cache")
+	}
+}
+
+func TestAdd_Persists(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.pdf")
+	if err := os.WriteFile(file, []byte("%PDF-1.7"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hash, size, err := HashFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != 8 || hash == "" {
+		t.Fatalf("got hash %q and size %d", hash, size)
+	}
+
+	path := filepath.Join(dir, "cache.json")
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Add(Entry{Hash: hash, NewName: "a.pdf", Size: size}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e, ok := reopened.Get(hash)
+	if !ok || e.NewName != "a.pdf" || e.Size != size || e.CreatedAt.IsZero() {
+		t.Errorf("got entry %+v, %v", e, ok)
+	}
+}
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -11,6 +13,8 @@ import (
 	"strings"
 )
 
+var ErrNoCredits = errors.New("There are no credentials with credits left")
+
 const (
 	configDir      = "pressgo"
 	configFileName = ".config.json"
@@ -20,6 +24,10 @@ const (
 
 type Config struct {
 	Credentials map[string]Credential `json:"credentials"`
+	Settings    Settings              `json:"settings,omitzero"`
+
+	// key is set when the config file is stored encrypted.
+	key *sealKey
 }
 
 type Credential struct {
@@ -50,55 +58,108 @@ func getConfigFilePath() (string, error) {
 
 func write(configFilePath string, cfg Config) error {
 	dir := filepath.Dir(configFilePath)
-	if _, err := os.Stat(dir); err != nil && errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(dir, 0755)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetIndent("", "\t")
+
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+
+	content := data.Bytes()
+	if cfg.key != nil {
+		encrypted, err := encrypt(content, cfg.key)
 		if err != nil {
 			return err
 		}
+		content = encrypted
 	}
 
-	configFile, err := os.Create(configFilePath)
+	// The config is written next to the old one and moved over it, so a crash
+	// mid-write can't leave the credentials truncated.
+	tmpPath := configFilePath + ".tmp"
+	configFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	defer configFile.Close()
 
-	encoder := json.NewEncoder(configFile)
-	encoder.SetIndent("", "\t")
+	// A temp file left by an older version may keep a looser mode.
+	if err := configFile.Chmod(0600); err != nil {
+		configFile.Close()
+		return err
+	}
 
-	if err := encoder.Encode(cfg); err != nil {
+	if _, err := configFile.Write(content); err != nil {
+		configFile.Close()
 		return err
 	}
 
-	return nil
+	if err := configFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, configFilePath)
 }
 
-func read(configFilePath string) (Config, error) {
+func read(configFilePath string, getPassphrase func() (string, error)) (Config, error) {
 	if _, err := os.Stat(configFilePath); err != nil && errors.Is(err, os.ErrNotExist) {
 		write(configFilePath, Config{Credentials: map[string]Credential{}})
 	}
 
-	jsonFile, err := os.Open(configFilePath)
+	data, err := os.ReadFile(configFilePath)
 	if err != nil {
 		return Config{}, err
 	}
-	defer jsonFile.Close()
+
+	var key *sealKey
+	if isEncrypted(data) {
+		if getPassphrase == nil {
+			return Config{}, fmt.Errorf("The credentials are locked, set %s to unlock them", PassphraseEnv)
+		}
+
+		passphrase, err := getPassphrase()
+		if err != nil {
+			return Config{}, err
+		}
+
+		data, key, err = decrypt(data, passphrase)
+		if err != nil {
+			return Config{}, err
+		}
+	}
 
 	var cfg Config
-	if err := json.NewDecoder(jsonFile).Decode(&cfg); err != nil {
+	if err := json.Unmarshal(data, &cfg); err != nil {
 		return Config{}, err
 	}
+	cfg.key = key
 
 	return cfg, nil
 }
 
-func Read() (Config, error) {
+// Read loads the config file. A locked file is opened with the passphrase in
+// PRESSGO_PASSPHRASE, or the one returned by prompt when it is not set.
+func Read(prompt func() (string, error)) (Config, error) {
 	configFilePath, err := getConfigFilePath()
 	if err != nil {
 		return Config{}, err
 	}
 
-	cfg, err := read(configFilePath)
+	getPassphrase := func() (string, error) {
+		if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+			return passphrase, nil
+		}
+		if prompt == nil {
+			return "", fmt.Errorf("The credentials are locked, set %s to unlock them", PassphraseEnv)
+		}
+		return prompt()
+	}
+
+	cfg, err := read(configFilePath, getPassphrase)
 	if err != nil {
 		return Config{}, err
 	}
@@ -172,6 +233,134 @@ func (c *Config) ActivateCredential(id string) error {
 	return c.activateCredential(configFilePath, id)
 }
 
+func (c *Config) GetActiveCredential() (string, Credential, error) {
+	for key, value := range c.Credentials {
+		if value.Status {
+			return key, value, nil
+		}
+	}
+
+	return "", Credential{}, fmt.Errorf("There is no active credential")
+}
+
+func (c *Config) setToken(configFilePath, id, token string) error {
+	credential, ok := c.Credentials[id]
+	if !ok {
+		return fmt.Errorf("The credential id doesn't exist")
+	}
+
+	credential.Token = token
+	c.Credentials[id] = credential
+	return write(configFilePath, *c)
+}
+
+func (c *Config) SetToken(id, token string) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	return c.setToken(configFilePath, id, token)
+}
+
+func (c *Config) setCredits(configFilePath, id string, credits int) error {
+	credential, ok := c.Credentials[id]
+	if !ok {
+		return fmt.Errorf("The credential id doesn't exist")
+	}
+
+	credential.Credits = credits
+	c.Credentials[id] = credential
+	return write(configFilePath, *c)
+}
+
+func (c *Config) SetCredits(id string, credits int) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	return c.setCredits(configFilePath, id, credits)
+}
+
+// exhaustCredential marks the credential as out of credits and activates the
+// next one, in id order, that still has credits left.
+func (c *Config) exhaustCredential(configFilePath, id string) (string, error) {
+	credential, ok := c.Credentials[id]
+	if !ok {
+		return "", fmt.Errorf("The credential id doesn't exist")
+	}
+
+	credential.Credits = 0
+	c.Credentials[id] = credential
+
+	ids := slices.Sorted(maps.Keys(c.Credentials))
+	for _, next := range ids {
+		if next != id && c.Credentials[next].Credits > 0 {
+			return next, c.activateCredential(configFilePath, next)
+		}
+	}
+
+	if err := write(configFilePath, *c); err != nil {
+		return "", err
+	}
+
+	return "", ErrNoCredits
+}
+
+func (c *Config) ExhaustCredential(id string) (string, error) {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	return c.exhaustCredential(configFilePath, id)
+}
+
+func (c *Config) IsLocked() bool {
+	return c.key != nil
+}
+
+func (c *Config) lock(configFilePath, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("The passphrase can't be empty")
+	}
+
+	// Locking is the only time a new salt is drawn; later writes reuse the key.
+	key, err := newSealKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	c.key = key
+	return write(configFilePath, *c)
+}
+
+// Lock encrypts the config file with passphrase. Calling it on a plaintext
+// config migrates it to the encrypted format.
+func (c *Config) Lock(passphrase string) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	return c.lock(configFilePath, passphrase)
+}
+
+func (c *Config) unlock(configFilePath string) error {
+	c.key = nil
+	return write(configFilePath, *c)
+}
+
+func (c *Config) Unlock() error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	return c.unlock(configFilePath)
+}
+
 type CredentialWithID struct {
 	ID string
 	Credential
@@ -206,6 +395,16 @@ func (c *Config) GetCredentials() [][]string {
 	return credentials
 }
 
+// AvailableCredits returns the credits left across all the credentials, as
+// last reported by the API.
+func (c *Config) AvailableCredits() int {
+	total := 0
+	for _, credential := range c.Credentials {
+		total += credential.Credits
+	}
+	return total
+}
+
 func safeTruncate(s string, n int) string {
 	if len(s) <= n {
 		return s
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -52,11 +55,42 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWrite_Atomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	old := Config{Credentials: map[string]Credential{"credential1": {Key: "key1"}}}
+	write(path, old)
+
+	// A directory where the temp file goes makes the write fail before the
+	// config is touched.
+	if err := os.Mkdir(path+".tmp", 0700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := write(path, Config{Credentials: map[string]Credential{}}); err == nil {
+		t.Fatal("expected an error writing over the temp directory")
+	}
+
+	got, err := read(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(old, got) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", got, old)
+	}
+
+	os.Remove(path + ".tmp")
+	if err := write(path, old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no temp file left, got %v", err)
+	}
+}
+
 func TestRead_FileNotExist(t *testing.T) {
 	path := filepath.Join(t.TempDir(), configFileName)
 	expected := Config{Credentials: map[string]Credential{}}
 
-	cfg, err := read(path)
+	cfg, err := read(path, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -80,7 +114,7 @@ func TestRead_FileExist(t *testing.T) {
 	}
 
 	write(path, expected)
-	cfg, err := read(path)
+	cfg, err := read(path, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -217,3 +251,322 @@ func TestActivateCredential(t *testing.T) {
 		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", cfg, expected)
 	}
 }
+
+func TestGetActiveCredential(t *testing.T) {
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"credential1": {Key: "key1"},
+			"credential2": {Key: "key2", Status: true},
+		},
+	}
+
+	id, cred, err := cfg.GetActiveCredential()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if id != "credential2" || cred.Key != "key2" {
+		t.Errorf("got %q %+v, want credential2", id, cred)
+	}
+}
+
+func TestGetActiveCredential_NoActive(t *testing.T) {
+	cfg := Config{Credentials: map[string]Credential{"credential1": {}}}
+
+	if _, _, err := cfg.GetActiveCredential(); err == nil {
+		t.Error("expected an error when no credential is active")
+	}
+}
+
+func TestSetToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"credential1": {Key: "key1", Token: "old"},
+		},
+	}
+	expected := Config{Credentials: map[string]Credential{
+		"credential1": {Key: "key1", Token: "new"},
+	}}
+
+	err := cfg.setToken(path, "credential1", "new")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, cfg) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", cfg, expected)
+	}
+}
+
+func TestSetCredits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{"credential1": {Credits: 100}}}
+	expected := Config{Credentials: map[string]Credential{"credential1": {Credits: 42}}}
+
+	err := cfg.setCredits(path, "credential1", 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, cfg) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", cfg, expected)
+	}
+}
+
+func TestExhaustCredential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"credential1": {Credits: 3, Status: true},
+			"credential2": {Credits: 0},
+			"credential3": {Credits: 250},
+		},
+	}
+	expected := Config{Credentials: map[string]Credential{
+		"credential1": {Credits: 0, Status: false},
+		"credential2": {Credits: 0, Status: false},
+		"credential3": {Credits: 250, Status: true},
+	}}
+
+	next, err := cfg.exhaustCredential(path, "credential1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if next != "credential3" {
+		t.Errorf("got %q, want %q", next, "credential3")
+	}
+	if !reflect.DeepEqual(expected, cfg) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", cfg, expected)
+	}
+}
+
+func TestExhaustCredential_NoCreditsLeft(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"credential1": {Credits: 3, Status: true},
+			"credential2": {Credits: 0},
+		},
+	}
+
+	_, err := cfg.exhaustCredential(path, "credential1")
+	if !errors.Is(err, ErrNoCredits) {
+		t.Fatalf("got %v, want %v", err, ErrNoCredits)
+	}
+
+	if cfg.Credentials["credential1"].Credits != 0 {
+		t.Error("Expected exhausted credential to have 0 credits")
+	}
+}
+
+func TestAvailableCredits(t *testing.T) {
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"credential1": {Credits: 3, Status: true},
+			"credential2": {Credits: 0},
+			"credential3": {Credits: 250},
+		},
+	}
+
+	if got := cfg.AvailableCredits(); got != 253 {
+		t.Errorf("got %d credits, want 253", got)
+	}
+}
+
+func TestWrite_Permissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configDir, configFileName)
+
+	err := write(path, Config{Credentials: map[string]Credential{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("file was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got file mode %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	info, err = os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("dir was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("got dir mode %v, want %v", info.Mode().Perm(), os.FileMode(0700))
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{
+		Credentials: map[string]Credential{
+			"test@test.com": {Key: "api-key-123", Token: "token", Credits: 100, Status: true},
+		},
+	}
+	write(path, cfg)
+
+	err := cfg.lock(path, "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !isEncrypted(data) || strings.Contains(string(data), "api-key-123") {
+		t.Fatalf("expected an encrypted config file, got %s", data)
+	}
+
+	got, err := read(path, func() (string, error) { return "secret", nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(cfg, got) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", got, cfg)
+	}
+}
+
+func TestSetCredits_LockedReusesKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{"credential1": {Credits: 100}}}
+	cfg.lock(path, "secret")
+	salt := readSalt(t, path)
+
+	key := cfg.key
+	if err := cfg.setCredits(path, "credential1", 42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.key != key {
+		t.Error("expected the key to be reused")
+	}
+	if got := readSalt(t, path); !bytes.Equal(got, salt) {
+		t.Errorf("got salt %x, want %x", got, salt)
+	}
+
+	got, err := read(path, func() (string, error) { return "secret", nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Credentials["credential1"].Credits != 42 {
+		t.Errorf("got %d credits, want 42", got.Credentials["credential1"].Credits)
+	}
+
+	if err := got.lock(path, "other"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(readSalt(t, path), salt) {
+		t.Error("expected locking again to draw a new salt")
+	}
+}
+
+func readSalt(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var enc encryptedConfig
+	if err := json.Unmarshal(data, &enc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return enc.Salt
+}
+
+func TestRead_WrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{}}
+	cfg.lock(path, "secret")
+
+	_, err := read(path, func() (string, error) { return "wrong", nil })
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("got %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestRead_LockedWithoutPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{}}
+	cfg.lock(path, "secret")
+
+	if _, err := read(path, nil); err == nil {
+		t.Error("expected an error reading a locked config without a passphrase")
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{"credential1": {Key: "key1"}}}
+	cfg.lock(path, "secret")
+
+	err := cfg.unlock(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := read(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.IsLocked() || !reflect.DeepEqual(cfg, got) {
+		t.Errorf("Config mismatch.\nGot:  %+v\nWant: %+v", got, cfg)
+	}
+}
+
+func TestSetSetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	cfg := Config{Credentials: map[string]Credential{}}
+	expected := Settings{MaxAttempts: 5, RetryDelay: "250ms", Workers: 8, RateLimit: 2.5}
+
+	for name, value := range map[string]string{
+		SettingMaxAttempts: "5",
+		SettingRetryDelay:  "250ms",
+		SettingWorkers:     "8",
+		SettingRateLimit:   "2.5",
+	} {
+		if err := cfg.setSetting(path, name, value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	saved, err := read(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(expected, saved.Settings) {
+		t.Errorf("Settings mismatch.\nGot:  %+v\nWant: %+v", saved.Settings, expected)
+	}
+}
+
+func TestSetSetting_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	tests := map[string][2]string{
+		"unknown setting":  {"colour", "blue"},
+		"zero attempts":    {SettingMaxAttempts, "0"},
+		"not a number":     {SettingMaxAttempts, "many"},
+		"not a duration":   {SettingRetryDelay, "soon"},
+		"negative backoff": {SettingRetryDelay, "-1s"},
+		"zero workers":     {SettingWorkers, "0"},
+		"negative rate":    {SettingRateLimit, "-1"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{Credentials: map[string]Credential{}}
+			if err := cfg.setSetting(path, tt[0], tt[1]); err == nil {
+				t.Errorf("expected an error setting %s to %q", tt[0], tt[1])
+			}
+			if cfg.Settings != (Settings{}) {
+				t.Errorf("expected the settings to be unchanged, got %+v", cfg.Settings)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	PassphraseEnv = "PRESSGO_PASSPHRASE"
+
+	encryptionVersion = 1
+	kdfName           = "pbkdf2-sha256"
+	kdfIterations     = 600_000
+	saltSize          = 16
+	keySize           = 32
+)
+
+var ErrWrongPassphrase = errors.New("The passphrase is not correct")
+
+// encryptedConfig is the on-disk format of a locked config file. The
+// credentials are sealed with AES-256-GCM under a key derived from the
+// passphrase, so nothing but the KDF parameters is stored in clear.
+type encryptedConfig struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func isEncrypted(data []byte) bool {
+	var enc encryptedConfig
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return false
+	}
+
+	return len(enc.Ciphertext) > 0
+}
+
+// sealKey is a key derived from the passphrase. It is derived once, when the
+// config is read or locked, and reused for every write so saving the credits
+// doesn't pay for the KDF again.
+type sealKey struct {
+	salt       []byte
+	iterations int
+	key        []byte
+}
+
+func deriveKey(passphrase string, salt []byte, iterations int) (*sealKey, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealKey{salt: salt, iterations: iterations, key: key}, nil
+}
+
+// newSealKey derives a key from passphrase under a fresh salt.
+func newSealKey(passphrase string) (*sealKey, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return deriveKey(passphrase, salt, kdfIterations)
+}
+
+func (k *sealKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encrypt(plaintext []byte, key *sealKey) ([]byte, error) {
+	gcm, err := key.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedConfig{
+		Version:    encryptionVersion,
+		KDF:        kdfName,
+		Iterations: key.iterations,
+		Salt:       key.salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "\t")
+}
+
+// decrypt opens a locked config file and returns the key it was sealed with,
+// so later writes can reuse it.
+func decrypt(data []byte, passphrase string) ([]byte, *sealKey, error) {
+	var enc encryptedConfig
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, nil, err
+	}
+
+	if enc.Version != encryptionVersion || enc.KDF != kdfName {
+		return nil, nil, fmt.Errorf("Unsupported config encryption: version %d, kdf %q", enc.Version, enc.KDF)
+	}
+
+	key, err := deriveKey(passphrase, enc.Salt, enc.Iterations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := key.gcm()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(enc.Nonce) != gcm.NonceSize() {
+		return nil, nil, fmt.Errorf("The config file is corrupted")
+	}
+
+	plaintext, err := gcm.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+
+	return plaintext, key, nil
+}
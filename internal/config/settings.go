@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Names of the settings, as used by 'pressgo config'.
+const (
+	SettingMaxAttempts = "max_attempts"
+	SettingRetryDelay  = "retry_delay"
+	SettingWorkers     = "workers"
+	SettingRateLimit   = "rate_limit"
+)
+
+// Settings are the defaults of command flags. A zero value means the flag
+// keeps its built-in default.
+type Settings struct {
+	// MaxAttempts is how many times each API step is tried before giving up.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// RetryDelay is the wait before the first retry, as a Go duration; it
+	// doubles with every attempt.
+	RetryDelay string `json:"retry_delay,omitempty"`
+	// Workers is how many pdfs are compressed at the same time.
+	Workers int `json:"workers,omitempty"`
+	// RateLimit is the most API requests per second, across all workers.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+}
+
+func (c *Config) setSetting(configFilePath, name, value string) error {
+	settings := c.Settings
+
+	switch name {
+	case SettingMaxAttempts:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s must be a number greater than 0", name)
+		}
+		settings.MaxAttempts = n
+	case SettingRetryDelay:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s must be a positive duration, like 500ms or 2s", name)
+		}
+		settings.RetryDelay = value
+	case SettingWorkers:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s must be a number greater than 0", name)
+		}
+		settings.Workers = n
+	case SettingRateLimit:
+		r, err := strconv.ParseFloat(value, 64)
+		if err != nil || r < 0 {
+			return fmt.Errorf("%s must be a number of requests per second, 0 for no limit", name)
+		}
+		settings.RateLimit = r
+	default:
+		return fmt.Errorf("Unknown setting %q", name)
+	}
+
+	c.Settings = settings
+	return write(configFilePath, *c)
+}
+
+func (c *Config) SetSetting(name, value string) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+
+	return c.setSetting(configFilePath, name, value)
+}
+
+// GetSettings returns the name and value of every setting, with an empty
+// value for the ones that are not set.
+func (c *Config) GetSettings() [][]string {
+	settings := [][]string{
+		{SettingMaxAttempts, ""},
+		{SettingRateLimit, ""},
+		{SettingRetryDelay, c.Settings.RetryDelay},
+		{SettingWorkers, ""},
+	}
+	if c.Settings.MaxAttempts > 0 {
+		settings[0][1] = strconv.Itoa(c.Settings.MaxAttempts)
+	}
+	if c.Settings.RateLimit > 0 {
+		settings[1][1] = strconv.FormatFloat(c.Settings.RateLimit, 'f', -1, 64)
+	}
+	if c.Settings.Workers > 0 {
+		settings[3][1] = strconv.Itoa(c.Settings.Workers)
+	}
+	return settings
+}
@@ -0,0 +1,349 @@
+// Package fakeapi implements the parts of the iLovePDF REST API pressgo uses
+// on top of an httptest.Server, so commands can be tested without network
+// access or real credits.
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Steps that can be made to fail with FailNext.
+const (
+	StepAuth     = "auth"
+	StepStart    = "start"
+	StepUpload   = "upload"
+	StepProcess  = "process"
+	StepDownload = "download"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// Transform builds the processed output of a task from its uploaded
+	// files. By default the first file is returned unchanged.
+	Transform func(tool string, files [][]byte, params map[string]any) []byte
+
+	// RetryAfter, when set, is sent as the Retry-After header of injected
+	// 429 and 503 failures.
+	RetryAfter string
+
+	mu        sync.Mutex
+	projects  map[string]*project
+	tokens    map[string]string
+	tasks     map[string]*task
+	failures  map[string][]int
+	processed []map[string]any
+	issued    int
+	nextID    int
+}
+
+type project struct {
+	credits int
+}
+
+type task struct {
+	publicKey string
+	tool      string
+	files     map[string][]byte
+	output    []byte
+}
+
+func New() *Server {
+	s := &Server{
+		projects: map[string]*project{},
+		tokens:   map[string]string{},
+		tasks:    map[string]*task{},
+		failures: map[string][]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth", s.handleAuth)
+	mux.HandleFunc("GET /v1/start/{tool}/{region}", s.handleStart)
+	mux.HandleFunc("POST /v1/upload", s.handleUpload)
+	mux.HandleFunc("POST /v1/process", s.handleProcess)
+	mux.HandleFunc("GET /v1/download/{task}", s.handleDownload)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddKey registers a project public key with the given credits.
+func (s *Server) AddKey(publicKey string, credits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projects[publicKey] = &project{credits: credits}
+}
+
+func (s *Server) Credits(publicKey string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.projects[publicKey]; ok {
+		return p.credits
+	}
+	return 0
+}
+
+// FailNext makes the next call to step answer with status instead of
+// running. Calling it several times queues several failures.
+func (s *Server) FailNext(step string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[step] = append(s.failures[step], status)
+}
+
+// ExpireTokens invalidates every token issued so far.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]string{}
+}
+
+// TokensIssued returns how many tokens the server has handed out.
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Processed returns the bodies of the process requests received so far.
+func (s *Server) Processed() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]any(nil), s.processed...)
+}
+
+// Host is the host the server hands out as the task server.
+func (s *Server) Host() string {
+	u, _ := url.Parse(s.URL)
+	return u.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{"type": http.StatusText(status), "message": message},
+	})
+}
+
+// fail answers with a queued failure for step, if there is one.
+func (s *Server) fail(w http.ResponseWriter, step string) bool {
+	s.mu.Lock()
+	queue := s.failures[step]
+	if len(queue) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	status := queue[0]
+	s.failures[step] = queue[1:]
+	s.mu.Unlock()
+
+	if s.RetryAfter != "" && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+		w.Header().Set("Retry-After", s.RetryAfter)
+	}
+
+	writeError(w, status, fmt.Sprintf("injected %s failure", step))
+	return true
+}
+
+// authorize returns the public key the request's bearer token belongs to.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	publicKey, ok := s.tokens[token]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return "", false
+	}
+	return publicKey, true
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, StepAuth) {
+		return
+	}
+
+	var body struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.projects[body.PublicKey]; !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid public key")
+		return
+	}
+
+	token := s.newID("token")
+	s.tokens[token] = body.PublicKey
+	s.issued++
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, StepStart) {
+		return
+	}
+	publicKey, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.newID("task")
+	s.tasks[id] = &task{
+		publicKey: publicKey,
+		tool:      r.PathValue("tool"),
+		files:     map[string][]byte{},
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"server":            s.Host(),
+		"task":              id,
+		"remaining_credits": s.projects[publicKey].credits,
+	})
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, StepUpload) {
+		return
+	}
+	publicKey, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Missing file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Unreadable file")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[r.FormValue("task")]
+	if !ok || t.publicKey != publicKey {
+		writeError(w, http.StatusBadRequest, "Unknown task")
+		return
+	}
+
+	serverFilename := s.newID("file") + ".pdf"
+	t.files[serverFilename] = data
+	writeJSON(w, http.StatusOK, map[string]string{"server_filename": serverFilename})
+}
+
+func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, StepProcess) {
+		return
+	}
+	publicKey, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taskID, _ := body["task"].(string)
+	t, ok := s.tasks[taskID]
+	if !ok || t.publicKey != publicKey {
+		writeError(w, http.StatusBadRequest, "Unknown task")
+		return
+	}
+
+	var files [][]byte
+	list, _ := body["files"].([]any)
+	for _, f := range list {
+		entry, _ := f.(map[string]any)
+		name, _ := entry["server_filename"].(string)
+		data, ok := t.files[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Unknown server_filename")
+			return
+		}
+		files = append(files, data)
+	}
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, "No files to process")
+		return
+	}
+
+	p := s.projects[publicKey]
+	if p.credits < len(files) {
+		writeError(w, http.StatusPaymentRequired, "Not enough credits")
+		return
+	}
+	p.credits -= len(files)
+
+	if s.Transform != nil {
+		t.output = s.Transform(t.tool, files, body)
+	} else {
+		t.output = files[0]
+	}
+	s.processed = append(s.processed, body)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"download_filename": "output.pdf",
+		"filesize":          len(files[0]),
+		"output_filesize":   len(t.output),
+		"output_filenumber": 1,
+		"status":            "TaskSuccess",
+	})
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, StepDownload) {
+		return
+	}
+	publicKey, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	t, ok := s.tasks[r.PathValue("task")]
+	s.mu.Unlock()
+	if !ok || t.publicKey != publicKey || t.output == nil {
+		writeError(w, http.StatusNotFound, "Task not processed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Write(t.output)
+}
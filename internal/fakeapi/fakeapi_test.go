@@ -0,0 +1,155 @@
+package fakeapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+type call struct {
+	t     *testing.T
+	srv   *Server
+	token string
+}
+
+func (c *call) do(method, path, contentType string, body io.Reader, out any) int {
+	req, err := http.NewRequest(method, c.srv.URL+path, body)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if b, ok := out.(*[]byte); ok {
+			*b, _ = io.ReadAll(resp.Body)
+		} else {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c *call) postJSON(path string, in, out any) int {
+	b, _ := json.Marshal(in)
+	return c.do(http.MethodPost, path, "application/json", bytes.NewReader(b), out)
+}
+
+func (c *call) upload(task string, data []byte, out any) int {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("task", task)
+	fw, _ := mw.CreateFormFile("file", "a.pdf")
+	fw.Write(data)
+	mw.Close()
+	return c.do(http.MethodPost, "/v1/upload", mw.FormDataContentType(), &body, out)
+}
+
+func TestTaskFlow(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.AddKey("key", 5)
+	c := &call{t: t, srv: srv}
+
+	var auth struct{ Token string }
+	if status := c.postJSON("/v1/auth", map[string]string{"public_key": "key"}, &auth); status != http.StatusOK {
+		t.Fatalf("auth: got status %d", status)
+	}
+	c.token = auth.Token
+
+	var start struct {
+		Server           string
+		Task             string
+		RemainingCredits int `json:"remaining_credits"`
+	}
+	if status := c.do(http.MethodGet, "/v1/start/compress/us", "", nil, &start); status != http.StatusOK {
+		t.Fatalf("start: got status %d", status)
+	}
+	if start.Server != srv.Host() || start.RemainingCredits != 5 {
+		t.Errorf("got start response %+v", start)
+	}
+
+	var upload struct {
+		ServerFilename string `json:"server_filename"`
+	}
+	if status := c.upload(start.Task, []byte("%PDF-1.4 data"), &upload); status != http.StatusOK {
+		t.Fatalf("upload: got status %d", status)
+	}
+
+	process := map[string]any{
+		"task":  start.Task,
+		"tool":  "compress",
+		"files": []map[string]string{{"server_filename": upload.ServerFilename, "filename": "a.pdf"}},
+	}
+	if status := c.postJSON("/v1/process", process, nil); status != http.StatusOK {
+		t.Fatalf("process: got status %d", status)
+	}
+
+	var output []byte
+	if status := c.do(http.MethodGet, "/v1/download/"+start.Task, "", nil, &output); status != http.StatusOK {
+		t.Fatalf("download: got status %d", status)
+	}
+	if string(output) != "%PDF-1.4 data" {
+		t.Errorf("got output %q", output)
+	}
+
+	if srv.Credits("key") != 4 {
+		t.Errorf("got %d credits, want 4", srv.Credits("key"))
+	}
+	if len(srv.Processed()) != 1 {
+		t.Errorf("got %d processed tasks, want 1", len(srv.Processed()))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+	srv.AddKey("key", 0)
+	c := &call{t: t, srv: srv}
+
+	if status := c.postJSON("/v1/auth", map[string]string{"public_key": "unknown"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("unknown key: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	var auth struct{ Token string }
+	c.postJSON("/v1/auth", map[string]string{"public_key": "key"}, &auth)
+	c.token = auth.Token
+
+	srv.FailNext(StepStart, http.StatusServiceUnavailable)
+	if status := c.do(http.MethodGet, "/v1/start/compress/us", "", nil, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("injected failure: got status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	var start struct{ Task string }
+	c.do(http.MethodGet, "/v1/start/compress/us", "", nil, &start)
+	var upload struct {
+		ServerFilename string `json:"server_filename"`
+	}
+	c.upload(start.Task, []byte("data"), &upload)
+
+	process := map[string]any{
+		"task":  start.Task,
+		"files": []map[string]string{{"server_filename": upload.ServerFilename}},
+	}
+	if status := c.postJSON("/v1/process", process, nil); status != http.StatusPaymentRequired {
+		t.Errorf("no credits: got status %d, want %d", status, http.StatusPaymentRequired)
+	}
+
+	srv.ExpireTokens()
+	if status := c.do(http.MethodGet, "/v1/start/compress/us", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("expired token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
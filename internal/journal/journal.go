@@ -0,0 +1,146 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Pending    State = "pending"
+	Uploaded   State = "uploaded"
+	Processed  State = "processed"
+	Downloaded State = "downloaded"
+	Verified   State = "verified"
+)
+
+type Entry struct {
+	Filename       string `json:"filename"`
+	NewName        string `json:"new_name"`
+	State          State  `json:"state"`
+	CredentialID   string `json:"credential_id,omitempty"`
+	Server         string `json:"server,omitempty"`
+	Task           string `json:"task,omitempty"`
+	ServerFilename string `json:"server_filename,omitempty"`
+	// Unlocked is the unlocked copy of an encrypted file, the one that is
+	// uploaded, until the output is downloaded.
+	Unlocked  string    `json:"unlocked,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Journal records how far each file of a compress run got, so an interrupted
+// run can be resumed without repeating the steps that already cost credits.
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func New(path string) *Journal {
+	return &Journal{
+		path:    path,
+		entries: map[string]Entry{},
+	}
+}
+
+func Open(path string) (*Journal, error) {
+	j := New(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		j.entries[e.Filename] = e
+	}
+
+	return j, nil
+}
+
+// Get returns the entry for filename, or a pending one if the journal has no
+// record of it yet.
+func (j *Journal) Get(filename, newName string) Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if e, ok := j.entries[filename]; ok {
+		return e
+	}
+
+	return Entry{Filename: filename, NewName: newName, State: Pending}
+}
+
+func (j *Journal) Update(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e.UpdatedAt = time.Now()
+	j.entries[e.Filename] = e
+	return j.write()
+}
+
+// Owner returns the filename of the entry whose new name is newName, compared
+// ignoring case like most file systems do.
+func (j *Journal) Owner(newName string) (string, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, e := range j.sorted() {
+		if strings.EqualFold(e.NewName, newName) {
+			return e.Filename, true
+		}
+	}
+	return "", false
+}
+
+func (j *Journal) Unfinished() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var unfinished []Entry
+	for _, e := range j.sorted() {
+		if e.State != Verified {
+			unfinished = append(unfinished, e)
+		}
+	}
+
+	return unfinished
+}
+
+func (j *Journal) sorted() []Entry {
+	return slices.SortedFunc(maps.Values(j.entries), func(a, b Entry) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+}
+
+// write replaces the journal file atomically so a crash never leaves it
+// half written.
+func (j *Journal) write() error {
+	data, err := json.MarshalIndent(j.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := j.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, j.path)
+}
@@ -0,0 +1,91 @@
+package journal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpen_FileNotExist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(j.Unfinished()) != 0 {
+		t.Errorf("expected an empty journal, got %+v", j.Unfinished())
+	}
+}
+
+func TestGet_Unknown(t *testing.T) {
+	j := New(filepath.Join(t.TempDir(), "journal.json"))
+	expected := Entry{Filename: "a.pdf", NewName: "a-new.pdf", State: Pending}
+
+	got := j.Get("a.pdf", "a-new.pdf")
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Entry mismatch.\nGot:  %+v\nWant: %+v", got, expected)
+	}
+}
+
+func TestUpdate_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j := New(path)
+
+	entry := Entry{
+		Filename:       "a.pdf",
+		NewName:        "a-new.pdf",
+		State:          Processed,
+		CredentialID:   "credential1",
+		Server:         "api1.ilovepdf.com",
+		Task:           "task",
+		ServerFilename: "server.pdf",
+	}
+	if err := j.Update(entry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := reopened.Get("a.pdf", "a-new.pdf")
+	got.UpdatedAt = entry.UpdatedAt
+	if !reflect.DeepEqual(entry, got) {
+		t.Errorf("Entry mismatch.\nGot:  %+v\nWant: %+v", got, entry)
+	}
+}
+
+func TestUnfinished(t *testing.T) {
+	j := New(filepath.Join(t.TempDir(), "journal.json"))
+	j.Update(Entry{Filename: "c.pdf", State: Uploaded})
+	j.Update(Entry{Filename: "a.pdf", State: Verified})
+	j.Update(Entry{Filename: "b.pdf", State: Pending})
+
+	var got []string
+	for _, e := range j.Unfinished() {
+		got = append(got, e.Filename)
+	}
+
+	expected := []string{"b.pdf", "c.pdf"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	j := New(filepath.Join(t.TempDir(), "journal.json"))
+	if err := j.Update(Entry{Filename: "a/Report.pdf", NewName: "report.pdf", State: Verified}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, ok := j.Owner("Report.PDF"); !ok || got != "a/Report.pdf" {
+		t.Errorf("got %q, %v, want a/Report.pdf", got, ok)
+	}
+	if _, ok := j.Owner("other.pdf"); ok {
+		t.Error("expected no owner for other.pdf")
+	}
+}
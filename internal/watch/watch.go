@@ -0,0 +1,160 @@
+// Package watch reports files that appear or change under a directory tree,
+// using native notifications where available and polling elsewhere.
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultInterval = 2 * time.Second
+
+var errUnsupported = errors.New("watch: native notifications are not supported")
+
+type Options struct {
+	// Interval is how often the tree is scanned when polling.
+	Interval time.Duration
+	// Poll forces polling even where native notifications are available.
+	Poll bool
+	// Exclude is a directory whose contents are never reported.
+	Exclude string
+}
+
+// Watch reports the paths of the files under dir that are created or
+// written to until ctx is done. Files already in dir are reported first. A
+// file may be reported several times while it is being written.
+func Watch(ctx context.Context, dir string, opts Options) (<-chan string, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	out := make(chan string)
+	if !opts.Poll {
+		err := watchNative(ctx, dir, opts, out)
+		if err == nil {
+			return out, nil
+		}
+		if !errors.Is(err, errUnsupported) {
+			return nil, err
+		}
+	}
+
+	go poll(ctx, dir, opts, out)
+	return out, nil
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// poll scans the tree every interval and reports the files that are new or
+// whose size or modification time changed.
+func poll(ctx context.Context, dir string, opts Options, out chan<- string) {
+	defer close(out)
+
+	known := map[string]fileState{}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		seen := map[string]fileState{}
+		walk(dir, opts.Exclude, func(path string, info fs.FileInfo) {
+			seen[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		})
+
+		for path, state := range seen {
+			if prev, ok := known[path]; ok && prev == state {
+				continue
+			}
+			if !send(ctx, out, path) {
+				return
+			}
+		}
+		known = seen
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// walk calls f for every regular file under dir outside of exclude.
+// Unreadable entries are skipped, they may be gone by the time they are read.
+func walk(dir, exclude string, f func(path string, info fs.FileInfo)) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if excluded(path, exclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		f(path, info)
+		return nil
+	})
+}
+
+func excluded(path, exclude string) bool {
+	if exclude == "" {
+		return false
+	}
+	return path == exclude || strings.HasPrefix(path, exclude+string(filepath.Separator))
+}
+
+func send(ctx context.Context, out chan<- string, path string) bool {
+	select {
+	case out <- path:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// WaitStable blocks until the size and modification time of path stay the
+// same for a whole interval, which is how a file that is still being copied
+// or scanned is told apart from a finished one.
+func WaitStable(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	prev, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		cur, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if cur.Size() == prev.Size() && cur.ModTime().Equal(prev.ModTime()) {
+			return nil
+		}
+		prev = cur
+	}
+}
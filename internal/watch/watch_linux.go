@@ -0,0 +1,155 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	fileEvents = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+	// pollTimeout bounds how long the reader waits for events before checking
+	// whether the watch was cancelled, in milliseconds.
+	pollTimeout = 250
+)
+
+// inotify watches every directory of a tree; directories created later are
+// added as they appear.
+type inotify struct {
+	fd      int
+	exclude string
+	dirs    map[int]string
+}
+
+func watchNative(ctx context.Context, dir string, opts Options, out chan<- string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsupported, err)
+	}
+
+	w := &inotify{fd: fd, exclude: opts.Exclude, dirs: map[int]string{}}
+	if err := w.addTree(dir); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("%w: %v", errUnsupported, err)
+	}
+
+	go w.run(ctx, dir, out)
+	return nil
+}
+
+// addTree watches dir and the directories under it. Only a failure on dir
+// itself is reported.
+func (w *inotify) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if excluded(path, w.exclude) {
+			return filepath.SkipDir
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, path, fileEvents|unix.IN_ONLYDIR)
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+func (w *inotify) run(ctx context.Context, dir string, out chan<- string) {
+	defer close(out)
+	defer unix.Close(w.fd)
+
+	if !w.sendTree(ctx, dir, out) {
+		return
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, pollTimeout)
+		if n == 0 || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		n, err = unix.Read(w.fd, buf)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			start := off + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:min(start+nameLen, n)]), "\x00")
+			off = start + nameLen
+
+			if mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+				continue
+			}
+			parent, ok := w.dirs[wd]
+			if !ok || name == "" {
+				continue
+			}
+
+			path := filepath.Join(parent, name)
+			if excluded(path, w.exclude) {
+				continue
+			}
+			if mask&unix.IN_ISDIR != 0 {
+				// Files can land in a new directory before its watch is added.
+				if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					w.addTree(path)
+					if !w.sendTree(ctx, path, out) {
+						return
+					}
+				}
+				continue
+			}
+			if !send(ctx, out, path) {
+				return
+			}
+		}
+	}
+}
+
+func (w *inotify) sendTree(ctx context.Context, dir string, out chan<- string) bool {
+	var paths []string
+	walk(dir, w.exclude, func(path string, _ fs.FileInfo) {
+		paths = append(paths, path)
+	})
+
+	for _, path := range paths {
+		if !send(ctx, out, path) {
+			return false
+		}
+	}
+	return true
+}
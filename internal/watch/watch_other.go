@@ -0,0 +1,9 @@
+//go:build !linux
+
+package watch
+
+import "context"
+
+func watchNative(ctx context.Context, dir string, opts Options, out chan<- string) error {
+	return errUnsupported
+}
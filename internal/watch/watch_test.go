@@ -0,0 +1,102 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// next returns the next reported path that isn't in skip, failing the test
+// when none arrives in time.
+func next(t *testing.T, events <-chan string, skip map[string]bool) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case path, ok := <-events:
+			if !ok {
+				t.Fatal("the watch stopped")
+			}
+			if !skip[path] {
+				return path
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a file to be reported")
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for name, poll := range map[string]bool{"native": false, "poll": true} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			exclude := filepath.Join(dir, "out")
+			existing := filepath.Join(dir, "existing.pdf")
+			if err := os.Mkdir(exclude, 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := os.WriteFile(existing, []byte("a"), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := Watch(ctx, dir, Options{Interval: 20 * time.Millisecond, Poll: poll, Exclude: exclude})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := next(t, events, nil); got != existing {
+				t.Errorf("got %s, want %s", got, existing)
+			}
+
+			if err := os.WriteFile(filepath.Join(exclude, "ignored.pdf"), []byte("b"), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			created := filepath.Join(dir, "sub", "new.pdf")
+			if err := os.MkdirAll(filepath.Dir(created), 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := os.WriteFile(created, []byte("c"), 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := next(t, events, map[string]bool{existing: true}); got != created {
+				t.Errorf("got %s, want %s", got, created)
+			}
+
+			cancel()
+			for range events {
+			}
+		})
+	}
+}
+
+func TestWaitStable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "growing.pdf")
+	if err := os.WriteFile(path, []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go func() {
+		for i := range 3 {
+			time.Sleep(30 * time.Millisecond)
+			os.WriteFile(path, []byte("abcd"[:i+2]), 0644)
+		}
+	}()
+
+	if err := WaitStable(context.Background(), path, 60*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "abcd" {
+		t.Errorf("returned while the file was still growing: %q", data)
+	}
+
+	if err := WaitStable(context.Background(), path+".missing", time.Millisecond); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,238 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var ErrEncrypted = errors.New("pdfdoc: the document is encrypted")
+
+var (
+	objHeaderRegex = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	versionRegex   = regexp.MustCompile(`%PDF-(\d\.\d)`)
+)
+
+type Document struct {
+	Version string
+	Objects map[int]Object
+	Trailer Dict
+}
+
+// Parse reads every object in data. Objects are found by scanning for
+// "n g obj" headers rather than by trusting the cross-reference table, so
+// files with a damaged xref still load; when an object is defined more than
+// once, the definition closest to the end of the file wins.
+func Parse(data []byte) (*Document, error) {
+	doc := &Document{
+		Version: "1.4",
+		Objects: map[int]Object{},
+		Trailer: Dict{},
+	}
+
+	if m := versionRegex.FindSubmatch(data[:min(len(data), 1024)]); m != nil {
+		doc.Version = string(m[1])
+	}
+
+	offsets := map[int]int{}
+	define := func(num int, obj Object, offset int) {
+		if prev, ok := offsets[num]; ok && prev > offset {
+			return
+		}
+		offsets[num] = offset
+		doc.Objects[num] = obj
+	}
+
+	type objStream struct {
+		stream *Stream
+		offset int
+	}
+	var objStreams []objStream
+	var xrefTrailer Dict
+	xrefOffset := -1
+
+	pos := 0
+	for {
+		loc := objHeaderRegex.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start := pos + loc[0]
+		headerEnd := pos + loc[1]
+		if start > 0 && isRegular(data[start-1]) {
+			pos = headerEnd
+			continue
+		}
+
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		p := parser{data: data, pos: headerEnd}
+		obj, err := p.parseIndirect()
+		if err != nil {
+			pos = headerEnd
+			continue
+		}
+		pos = p.pos
+
+		define(num, obj, start)
+		if s, ok := obj.(*Stream); ok {
+			switch s.Dict.Name("Type") {
+			case "ObjStm":
+				objStreams = append(objStreams, objStream{stream: s, offset: start})
+			case "XRef":
+				if start > xrefOffset {
+					xrefTrailer, xrefOffset = s.Dict, start
+				}
+			}
+		}
+	}
+
+	if len(doc.Objects) == 0 {
+		return nil, fmt.Errorf("pdfdoc: no objects found")
+	}
+
+	for _, objStm := range objStreams {
+		objs, err := parseObjectStream(objStm.stream)
+		if err != nil {
+			continue
+		}
+		for num, obj := range objs {
+			define(num, obj, objStm.offset)
+		}
+	}
+
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		p := parser{data: data, pos: i + len("trailer")}
+		if v, err := p.parseValue(); err == nil {
+			if d, ok := v.(Dict); ok && i > xrefOffset {
+				doc.Trailer = d
+			}
+		}
+	}
+	if _, ok := doc.Trailer["Root"]; !ok && xrefTrailer != nil {
+		doc.Trailer = xrefTrailer
+	}
+	if _, ok := doc.Trailer["Root"]; !ok {
+		for num, obj := range doc.Objects {
+			if d, ok := obj.(Dict); ok && d.Name("Type") == "Catalog" {
+				doc.Trailer["Root"] = Ref{Num: num}
+				break
+			}
+		}
+	}
+	if _, ok := doc.Trailer["Root"]; !ok {
+		return nil, fmt.Errorf("pdfdoc: document catalog not found")
+	}
+
+	return doc, nil
+}
+
+func parseObjectStream(s *Stream) (map[int]Object, error) {
+	data, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	n, _ := s.Dict.Int("N")
+	first, _ := s.Dict.Int("First")
+	if first > len(data) {
+		return nil, fmt.Errorf("pdfdoc: bad object stream")
+	}
+
+	p := parser{data: data[:first]}
+	objs := map[int]Object{}
+	for range n {
+		num, err1 := p.parseValue()
+		offset, err2 := p.parseValue()
+		if err1 != nil || err2 != nil {
+			break
+		}
+		numInt, ok1 := num.(int)
+		offsetInt, ok2 := offset.(int)
+		if !ok1 || !ok2 || first+offsetInt > len(data) {
+			break
+		}
+
+		q := parser{data: data, pos: first + offsetInt}
+		obj, err := q.parseValue()
+		if err != nil {
+			continue
+		}
+		objs[numInt] = obj
+	}
+
+	return objs, nil
+}
+
+// Resolve follows references until it reaches a direct object.
+func (d *Document) Resolve(obj Object) Object {
+	for range 32 {
+		ref, ok := obj.(Ref)
+		if !ok {
+			return obj
+		}
+		obj = d.Objects[ref.Num]
+	}
+	return nil
+}
+
+func (d *Document) Encrypted() bool {
+	_, ok := d.Trailer["Encrypt"]
+	return ok
+}
+
+func (d *Document) Catalog() Dict {
+	catalog, _ := d.Resolve(d.Trailer["Root"]).(Dict)
+	return catalog
+}
+
+func (d *Document) PageCount() (int, error) {
+	pages, ok := d.Resolve(d.Catalog()["Pages"]).(Dict)
+	if !ok {
+		return 0, fmt.Errorf("pdfdoc: page tree not found")
+	}
+
+	count, ok := d.Resolve(pages["Count"]).(int)
+	if !ok {
+		return 0, fmt.Errorf("pdfdoc: page count not found")
+	}
+
+	return count, nil
+}
+
+// SetInfo sets the given entries of the document information dictionary,
+// creating it if needed.
+func (d *Document) SetInfo(entries map[Name]string) {
+	info, ok := d.Resolve(d.Trailer["Info"]).(Dict)
+	if !ok {
+		info = Dict{}
+	}
+
+	updated := Dict{}
+	for k, v := range info {
+		updated[k] = v
+	}
+	for k, v := range entries {
+		if v != "" {
+			updated[k] = TextString(v)
+		}
+	}
+
+	if ref, ok := d.Trailer["Info"].(Ref); ok {
+		d.Objects[ref.Num] = updated
+		return
+	}
+
+	num := d.maxObjectNumber() + 1
+	d.Objects[num] = updated
+	d.Trailer["Info"] = Ref{Num: num}
+}
+
+func (d *Document) maxObjectNumber() int {
+	highest := 0
+	for num := range d.Objects {
+		highest = max(highest, num)
+	}
+	return highest
+}
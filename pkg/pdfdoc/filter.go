@@ -0,0 +1,154 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnsupportedFilter = errors.New("pdfdoc: unsupported stream filter")
+
+// Filters returns the filter names of s in the order they must be decoded.
+func Filters(s *Stream) []Name {
+	switch f := s.Dict["Filter"].(type) {
+	case Name:
+		return []Name{f}
+	case Array:
+		var names []Name
+		for _, v := range f {
+			if n, ok := v.(Name); ok {
+				names = append(names, n)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// Decode returns the decoded data of s. Only FlateDecode, optionally with a
+// PNG predictor, is supported.
+func Decode(s *Stream) ([]byte, error) {
+	filters := Filters(s)
+	if len(filters) == 0 {
+		return s.Data, nil
+	}
+	if len(filters) > 1 || (filters[0] != "FlateDecode" && filters[0] != "Fl") {
+		return nil, ErrUnsupportedFilter
+	}
+
+	data, err := inflate(s.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	parms, _ := s.Dict["DecodeParms"].(Dict)
+	if arr, ok := s.Dict["DecodeParms"].(Array); ok && len(arr) == 1 {
+		parms, _ = arr[0].(Dict)
+	}
+	predictor, _ := parms.Int("Predictor")
+	if predictor <= 1 {
+		return data, nil
+	}
+	if predictor < 10 {
+		return nil, ErrUnsupportedFilter
+	}
+
+	colors, ok := parms.Int("Colors")
+	if !ok {
+		colors = 1
+	}
+	bpc, ok := parms.Int("BitsPerComponent")
+	if !ok {
+		bpc = 8
+	}
+	columns, ok := parms.Int("Columns")
+	if !ok {
+		columns = 1
+	}
+
+	return unpredictPNG(data, colors, bpc, columns)
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Many writers leave a truncated or checksum-less zlib stream, keep what
+	// was decoded as long as there is something.
+	out, err := io.ReadAll(r)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func unpredictPNG(data []byte, colors, bpc, columns int) ([]byte, error) {
+	bpp := max(1, colors*bpc/8)
+	rowSize := (colors*bpc*columns + 7) / 8
+	if rowSize <= 0 {
+		return nil, fmt.Errorf("pdfdoc: bad predictor parameters")
+	}
+
+	var out []byte
+	prev := make([]byte, rowSize)
+	for i := 0; i+rowSize+1 <= len(data); i += rowSize + 1 {
+		tag := data[i]
+		row := append([]byte(nil), data[i+1:i+1+rowSize]...)
+		for j := range row {
+			var left, up, upLeft byte
+			if j >= bpp {
+				left = row[j-bpp]
+				upLeft = prev[j-bpp]
+			}
+			up = prev[j]
+
+			switch tag {
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += up
+			case 3:
+				row[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[j] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
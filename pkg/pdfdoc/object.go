@@ -0,0 +1,59 @@
+package pdfdoc
+
+// The PDF object types. Booleans, integers and reals are stored as bool, int
+// and float64, and the null object as nil.
+type (
+	Object any
+	Name   string
+	String []byte
+	Array  []Object
+	Dict   map[Name]Object
+)
+
+type Ref struct {
+	Num int
+	Gen int
+}
+
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+func (d Dict) Name(key Name) Name {
+	n, _ := d[key].(Name)
+	return n
+}
+
+func (d Dict) Int(key Name) (int, bool) {
+	n, ok := d[key].(int)
+	return n, ok
+}
+
+// TextString encodes s as a PDF text string, using UTF-16BE when it doesn't
+// fit in plain ASCII.
+func TextString(s string) String {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return String(s)
+	}
+
+	out := []byte{0xfe, 0xff}
+	for _, r := range s {
+		if r > 0xffff {
+			r -= 0x10000
+			hi, lo := 0xd800+(r>>10), 0xdc00+(r&0x3ff)
+			out = append(out, byte(hi>>8), byte(hi), byte(lo>>8), byte(lo))
+			continue
+		}
+		out = append(out, byte(r>>8), byte(r))
+	}
+
+	return String(out)
+}
@@ -0,0 +1,268 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+)
+
+const (
+	DefaultImageQuality = 75
+	DefaultMaxImageSize = 2000
+)
+
+type Options struct {
+	Title  string
+	Author string
+
+	// ImageQuality is the JPEG quality used to re-encode images.
+	ImageQuality int
+	// MaxImageSize is the longest side, in pixels, an image keeps; larger
+	// images are downsampled.
+	MaxImageSize int
+}
+
+// Optimize rewrites a PDF to make it smaller: streams are recompressed,
+// JPEG images are downsampled and re-encoded, identical objects are merged
+// and objects nothing refers to are dropped.
+func Optimize(data []byte, opts Options) ([]byte, error) {
+	if opts.ImageQuality <= 0 {
+		opts.ImageQuality = DefaultImageQuality
+	}
+	if opts.MaxImageSize <= 0 {
+		opts.MaxImageSize = DefaultMaxImageSize
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Encrypted() {
+		return nil, ErrEncrypted
+	}
+
+	for _, obj := range doc.Objects {
+		s, ok := obj.(*Stream)
+		if !ok {
+			continue
+		}
+
+		if s.Dict.Name("Subtype") == "Image" {
+			optimizeImage(s, opts)
+		}
+		recompress(s)
+	}
+
+	doc.Dedup()
+	doc.SetInfo(map[Name]string{"Title": opts.Title, "Author": opts.Author})
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// recompress deflates raw and Flate streams at the best compression level,
+// keeping the result only when it is smaller.
+func recompress(s *Stream) {
+	if s.Dict.Name("Type") == "Metadata" {
+		return
+	}
+
+	filters := Filters(s)
+	if len(filters) > 1 || (len(filters) == 1 && filters[0] != "FlateDecode" && filters[0] != "Fl") {
+		return
+	}
+
+	decoded, err := Decode(s)
+	if err != nil {
+		return
+	}
+
+	compressed := deflate(decoded)
+	if len(compressed) >= len(s.Data) {
+		return
+	}
+
+	s.Data = compressed
+	s.Dict["Filter"] = Name("FlateDecode")
+	delete(s.Dict, "DecodeParms")
+}
+
+// optimizeImage downsamples and re-encodes 8-bit gray and RGB JPEG images.
+// Anything with a colour transform the encoder can't reproduce is left
+// alone.
+func optimizeImage(s *Stream, opts Options) {
+	filters := Filters(s)
+	if len(filters) != 1 || (filters[0] != "DCTDecode" && filters[0] != "DCT") {
+		return
+	}
+
+	cs := s.Dict.Name("ColorSpace")
+	if cs != "DeviceRGB" && cs != "DeviceGray" {
+		return
+	}
+	if bpc, _ := s.Dict.Int("BitsPerComponent"); bpc != 8 {
+		return
+	}
+	if _, ok := s.Dict["Decode"]; ok {
+		return
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(s.Data))
+	if err != nil {
+		return
+	}
+	switch img.ColorModel() {
+	case color.YCbCrModel, color.GrayModel:
+	default:
+		return
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if longest := max(w, h); longest > opts.MaxImageSize {
+		w = max(1, w*opts.MaxImageSize/longest)
+		h = max(1, h*opts.MaxImageSize/longest)
+		img = downscale(img, w, h)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.ImageQuality}); err != nil {
+		return
+	}
+	if buf.Len() >= len(s.Data) {
+		return
+	}
+
+	s.Data = buf.Bytes()
+	s.Dict["Width"] = w
+	s.Dict["Height"] = h
+	delete(s.Dict, "DecodeParms")
+}
+
+// downscale resizes img to w x h by averaging the source pixels each
+// destination pixel covers.
+func downscale(img image.Image, w, h int) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	gray := img.ColorModel() == color.GrayModel
+	var dst image.Image
+	var set func(x, y int, r, g, b uint32)
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		dst = g
+		set = func(x, y int, r, _, _ uint32) { g.SetGray(x, y, color.Gray{Y: uint8(r >> 8)}) }
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		dst = rgba
+		set = func(x, y int, r, g, b uint32) {
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff})
+		}
+	}
+
+	for y := range h {
+		y0 := bounds.Min.Y + y*sh/h
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*sh/h)
+		for x := range w {
+			x0 := bounds.Min.X + x*sw/w
+			x1 := max(x0+1, bounds.Min.X+(x+1)*sw/w)
+
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := img.At(sx, sy).RGBA()
+					r, g, b, n = r+cr, g+cg, b+cb, n+1
+				}
+			}
+			set(x, y, r/n, g/n, b/n)
+		}
+	}
+
+	return dst
+}
+
+// Dedup merges objects with identical content, repeating until no more
+// duplicates appear since merging can make their parents identical too.
+// Page tree nodes are never merged as each must appear once in the tree.
+func (d *Document) Dedup() {
+	for range 8 {
+		canonical := map[string]int{}
+		remap := map[int]int{}
+
+		for _, num := range sortedNumbers(d.Objects) {
+			obj := d.Objects[num]
+			if dict, ok := d.Resolve(obj).(Dict); ok {
+				if t := dict.Name("Type"); t == "Page" || t == "Pages" || t == "Catalog" {
+					continue
+				}
+			}
+
+			var buf bytes.Buffer
+			writeObject(&buf, obj, nil)
+			if s, ok := obj.(*Stream); ok {
+				buf.WriteString("stream")
+				buf.Write(s.Data)
+			}
+
+			key := buf.String()
+			if first, ok := canonical[key]; ok {
+				remap[num] = first
+				continue
+			}
+			canonical[key] = num
+		}
+
+		if len(remap) == 0 {
+			return
+		}
+
+		for num := range remap {
+			delete(d.Objects, num)
+		}
+		for num, obj := range d.Objects {
+			d.Objects[num] = remapRefs(obj, remap)
+		}
+		d.Trailer = remapRefs(d.Trailer, remap).(Dict)
+	}
+}
+
+func sortedNumbers(objects map[int]Object) []int {
+	nums := make([]int, 0, len(objects))
+	for num := range objects {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+	return nums
+}
+
+func remapRefs(obj Object, remap map[int]int) Object {
+	switch v := obj.(type) {
+	case Ref:
+		if to, ok := remap[v.Num]; ok {
+			return Ref{Num: to}
+		}
+		return v
+	case Array:
+		out := make(Array, len(v))
+		for i, e := range v {
+			out[i] = remapRefs(e, remap)
+		}
+		return out
+	case Dict:
+		out := Dict{}
+		for k, e := range v {
+			out[k] = remapRefs(e, remap)
+		}
+		return out
+	case *Stream:
+		v.Dict = remapRefs(v.Dict, remap).(Dict)
+		return v
+	}
+	return obj
+}
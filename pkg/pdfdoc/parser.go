@@ -0,0 +1,373 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errNotValue = errors.New("pdfdoc: expected a value")
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func isWhite(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func isRegular(c byte) bool {
+	return !isWhite(c) && !isDelim(c)
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		c := p.data[p.pos]
+		switch {
+		case isWhite(c):
+			p.pos++
+		case c == '%':
+			for !p.eof() && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) keyword() string {
+	start := p.pos
+	for !p.eof() && isRegular(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// hasKeyword reports whether kw comes next, as a whole token.
+func (p *parser) hasKeyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.data) || string(p.data[p.pos:end]) != kw {
+		return false
+	}
+	return end == len(p.data) || !isRegular(p.data[end])
+}
+
+func (p *parser) parseValue() (Object, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, fmt.Errorf("pdfdoc: unexpected end of data")
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		return p.parseName(), nil
+	case c == '(':
+		return p.parseLiteralString()
+	case c == '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			return p.parseDict()
+		}
+		return p.parseHexString()
+	case c == '[':
+		return p.parseArray()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumberOrRef()
+	}
+
+	start := p.pos
+	switch kw := p.keyword(); kw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "":
+		p.pos++
+		return nil, fmt.Errorf("pdfdoc: unexpected %q at %d", p.data[start], start)
+	default:
+		p.pos = start
+		return nil, errNotValue
+	}
+}
+
+func (p *parser) parseName() Name {
+	p.pos++
+	var name []byte
+	for !p.eof() && isRegular(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				name = append(name, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		p.pos++
+	}
+	return Name(name)
+}
+
+func (p *parser) parseLiteralString() (String, error) {
+	p.pos++
+	var s []byte
+	depth := 1
+	for !p.eof() {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(s), nil
+			}
+		case '\\':
+			if p.eof() {
+				continue
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if !p.eof() && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && !p.eof() && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+
+	return nil, fmt.Errorf("pdfdoc: unterminated string")
+}
+
+func (p *parser) parseHexString() (String, error) {
+	p.pos++
+	var digits []byte
+	for !p.eof() {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			s := make([]byte, len(digits)/2)
+			for i := range s {
+				v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("pdfdoc: bad hex string")
+				}
+				s[i] = byte(v)
+			}
+			return String(s), nil
+		}
+		if !isWhite(c) {
+			digits = append(digits, c)
+		}
+	}
+
+	return nil, fmt.Errorf("pdfdoc: unterminated hex string")
+}
+
+func (p *parser) parseArray() (Array, error) {
+	p.pos++
+	arr := Array{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, fmt.Errorf("pdfdoc: unterminated array")
+		}
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return arr, nil
+		}
+
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+}
+
+func (p *parser) parseDict() (Dict, error) {
+	p.pos += 2
+	dict := Dict{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, fmt.Errorf("pdfdoc: unterminated dictionary")
+		}
+		if p.data[p.pos] == '>' {
+			if p.pos+1 < len(p.data) && p.data[p.pos+1] == '>' {
+				p.pos += 2
+				return dict, nil
+			}
+			return nil, fmt.Errorf("pdfdoc: bad dictionary end at %d", p.pos)
+		}
+		if p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("pdfdoc: dictionary key is not a name at %d", p.pos)
+		}
+
+		key := p.parseName()
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			dict[key] = v
+		}
+	}
+}
+
+func (p *parser) number() (string, bool) {
+	start := p.pos
+	real := false
+	if !p.eof() && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+		p.pos++
+	}
+	for !p.eof() {
+		c := p.data[p.pos]
+		if c == '.' {
+			real = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.data[start:p.pos]), real
+}
+
+func (p *parser) parseNumberOrRef() (Object, error) {
+	lexeme, real := p.number()
+	if real {
+		f, err := strconv.ParseFloat(lexeme, 64)
+		if err != nil {
+			return 0.0, nil
+		}
+		return f, nil
+	}
+
+	n, err := strconv.Atoi(lexeme)
+	if err != nil {
+		return 0, nil
+	}
+
+	// "n g R" is a reference, anything else leaves n as a plain integer.
+	save := p.pos
+	p.skipSpace()
+	if !p.eof() && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		genLexeme, genReal := p.number()
+		p.skipSpace()
+		if gen, err := strconv.Atoi(genLexeme); err == nil && !genReal && p.hasKeyword("R") {
+			p.pos++
+			return Ref{Num: n, Gen: gen}, nil
+		}
+	}
+	p.pos = save
+
+	return n, nil
+}
+
+// parseIndirect parses the body of an indirect object, the part after
+// "n g obj", including stream data when there is any.
+func (p *parser) parseIndirect() (Object, error) {
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	dict, ok := v.(Dict)
+	if !ok || !p.hasKeyword("stream") {
+		if p.hasKeyword("endobj") {
+			p.pos += len("endobj")
+		}
+		return v, nil
+	}
+
+	p.pos += len("stream")
+	if !p.eof() && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if !p.eof() && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	// Trust /Length only when endstream really follows it; it may also be an
+	// indirect reference that hasn't been parsed yet.
+	end := -1
+	if n, ok := dict.Int("Length"); ok && n >= 0 && start+n <= len(p.data) {
+		q := parser{data: p.data, pos: start + n}
+		q.skipSpace()
+		if q.hasKeyword("endstream") {
+			end = start + n
+		}
+	}
+	if end < 0 {
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil, fmt.Errorf("pdfdoc: unterminated stream")
+		}
+		end = start + i
+		if end > start && p.data[end-1] == '\n' {
+			end--
+		}
+		if end > start && p.data[end-1] == '\r' {
+			end--
+		}
+	}
+
+	data := make([]byte, end-start)
+	copy(data, p.data[start:end])
+
+	p.pos = end
+	p.skipSpace()
+	if p.hasKeyword("endstream") {
+		p.pos += len("endstream")
+	}
+	p.skipSpace()
+	if p.hasKeyword("endobj") {
+		p.pos += len("endobj")
+	}
+
+	return &Stream{Dict: dict, Data: data}, nil
+}
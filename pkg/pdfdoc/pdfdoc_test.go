@@ -0,0 +1,235 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF from object bodies numbered from 1, with a
+// correct cross-reference table.
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	offsets := []int{}
+	for i, obj := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func samplePDF() []byte {
+	content := strings.Repeat("BT /F1 12 Tf 72 712 Td (Hello) Tj ET\n", 50)
+	return buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 6 0 R >>",
+		stream("", content),
+		stream("", content),
+		"(an unreferenced object)",
+	)
+}
+
+// contentStreams returns the streams of doc that are not part of the file
+// structure.
+func contentStreams(doc *Document) []*Stream {
+	var streams []*Stream
+	for _, obj := range doc.Objects {
+		if s, ok := obj.(*Stream); ok && s.Dict.Name("Type") != "ObjStm" && s.Dict.Name("Type") != "XRef" {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(samplePDF())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if doc.Version != "1.5" {
+		t.Errorf("got version %q, want %q", doc.Version, "1.5")
+	}
+
+	count, err := doc.PageCount()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("got %d pages, want 2", count)
+	}
+}
+
+func TestParse_Values(t *testing.T) {
+	data := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R /Name /A#20B /Str (a\\(b\\)\\101) /Hex <4142 4> /Real -1.5 /Arr [1 2 0 R true null] >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	)
+
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	catalog := doc.Catalog()
+	if got := catalog.Name("Name"); got != "A B" {
+		t.Errorf("got name %q, want %q", got, "A B")
+	}
+	if got := string(catalog["Str"].(String)); got != "a(b)A" {
+		t.Errorf("got string %q, want %q", got, "a(b)A")
+	}
+	if got := string(catalog["Hex"].(String)); got != "AB@" {
+		t.Errorf("got hex string %q, want %q", got, "AB@")
+	}
+	if got := catalog["Real"].(float64); got != -1.5 {
+		t.Errorf("got real %v, want -1.5", got)
+	}
+	arr := catalog["Arr"].(Array)
+	if len(arr) != 4 || arr[1] != (Ref{Num: 2}) || arr[2] != true || arr[3] != nil {
+		t.Errorf("got array %#v", arr)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	input := samplePDF()
+
+	output, err := Optimize(input, Options{Title: "Título", Author: "Ann"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(output) >= len(input) {
+		t.Errorf("expected a smaller output, got %d bytes from %d", len(output), len(input))
+	}
+
+	doc, err := Parse(output)
+	if err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+
+	count, err := doc.PageCount()
+	if err != nil || count != 2 {
+		t.Errorf("got %d pages (%v), want 2", count, err)
+	}
+
+	// Both pages now share one content stream and the unreferenced string is gone.
+	streams := contentStreams(doc)
+	for _, s := range streams {
+		data, err := Decode(s)
+		if err != nil || !bytes.HasPrefix(data, []byte("BT /F1 12 Tf")) {
+			t.Errorf("content stream does not round trip: %v", err)
+		}
+	}
+	if len(streams) != 1 {
+		t.Errorf("got %d streams, want 1", len(streams))
+	}
+	for _, obj := range doc.Objects {
+		if _, ok := obj.(String); ok {
+			t.Error("expected unreferenced objects to be dropped")
+		}
+	}
+
+	info := doc.Resolve(doc.Trailer["Info"]).(Dict)
+	if got := info["Title"].(String); !bytes.Equal(got, TextString("Título")) {
+		t.Errorf("got title %q", got)
+	}
+	if got := string(info["Author"].(String)); got != "Ann" {
+		t.Errorf("got author %q, want %q", got, "Ann")
+	}
+}
+
+func TestOptimize_DownsamplesImages(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := range 200 {
+		for x := range 400 {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 100})
+
+	input := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 400 200] /Resources << /XObject << /Im1 4 0 R >> >> >>",
+		stream("/Type /XObject /Subtype /Image /Width 400 /Height 200 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode", jpg.String()),
+	)
+
+	output, err := Optimize(input, Options{MaxImageSize: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	doc, err := Parse(output)
+	if err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+
+	for _, s := range contentStreams(doc) {
+		w, _ := s.Dict.Int("Width")
+		h, _ := s.Dict.Int("Height")
+		if w != 100 || h != 50 {
+			t.Errorf("got image %dx%d, want 100x50", w, h)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(s.Data))
+		if err != nil || decoded.Bounds().Dx() != 100 {
+			t.Errorf("image data does not match its dictionary: %v", err)
+		}
+	}
+}
+
+func TestOptimize_Encrypted(t *testing.T) {
+	input := bytes.Replace(samplePDF(), []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt 7 0 R"), 1)
+
+	if _, err := Optimize(input, Options{}); err != ErrEncrypted {
+		t.Errorf("got %v, want %v", err, ErrEncrypted)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	valid := samplePDF()
+	optimized, _ := Optimize(valid, Options{})
+
+	tests := []struct {
+		name  string
+		data  []byte
+		pages int
+		fails bool
+	}{
+		{name: "valid", data: valid, pages: 2},
+		{name: "optimized", data: optimized, pages: 2},
+		{name: "no header", data: valid[9:], fails: true},
+		{name: "truncated", data: valid[:len(valid)-200], fails: true},
+		{name: "bad startxref", data: bytes.Replace(valid, []byte("startxref\n"), []byte("startxref\n1"), 1), fails: true},
+	}
+
+	for _, tt := range tests {
+		pages, err := Verify(tt.data)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil || pages != tt.pages {
+			t.Errorf("%s: got %d pages (%v), want %d", tt.name, pages, err, tt.pages)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package pdfdoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var (
+	ErrNoHeader  = errors.New("pdfdoc: missing %PDF- header")
+	ErrTruncated = errors.New("pdfdoc: missing %%EOF marker, the file is truncated")
+)
+
+var startxrefRegex = regexp.MustCompile(`startxref\s+(\d+)`)
+
+// Verify checks that data looks like a complete PDF: the header and end
+// marker are present, the last startxref points at a cross-reference section
+// and the page tree can be read. It returns the page count.
+func Verify(data []byte) (int, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return 0, ErrNoHeader
+	}
+	tail := data[max(0, len(data)-1024):]
+	if !bytes.Contains(tail, []byte("%%EOF")) {
+		return 0, ErrTruncated
+	}
+
+	matches := startxrefRegex.FindAllSubmatch(tail, -1)
+	if matches == nil {
+		return 0, fmt.Errorf("pdfdoc: missing startxref")
+	}
+	offset, err := strconv.Atoi(string(matches[len(matches)-1][1]))
+	if err != nil || offset >= len(data) {
+		return 0, fmt.Errorf("pdfdoc: startxref points outside the file")
+	}
+
+	p := parser{data: data, pos: offset}
+	p.skipSpace()
+	if !p.hasKeyword("xref") && objHeaderRegex.FindIndex(data[offset:min(len(data), offset+32)]) == nil {
+		return 0, fmt.Errorf("pdfdoc: startxref does not point to a cross-reference section")
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return doc.PageCount()
+}
@@ -0,0 +1,274 @@
+package pdfdoc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+const objectsPerStream = 100
+
+// Write serializes the objects reachable from the trailer, renumbered in the
+// order they are found. Streams are written as top level objects and
+// everything else is packed into compressed object streams, indexed by a
+// cross-reference stream.
+func (d *Document) Write(w io.Writer) error {
+	order, numbers := d.reachable()
+
+	version := d.Version
+	if version < "1.5" {
+		version = "1.5"
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	fmt.Fprintf(cw, "%%PDF-%s\n%%\xe2\xe3\xcf\xd3\n", version)
+
+	// Entries of the xref stream, indexed by the new object number: type 1
+	// entries hold a file offset and type 2 entries the number of the object
+	// stream and the index inside it.
+	type xrefEntry struct {
+		kind   byte
+		field2 int64
+		field3 int
+	}
+	size := len(order) + 1
+	entries := make([]xrefEntry, size, size+len(order)/objectsPerStream+2)
+
+	var buf bytes.Buffer
+	writeIndirect := func(num int, dict Dict, data []byte) {
+		entries[num] = xrefEntry{kind: 1, field2: cw.n}
+
+		buf.Reset()
+		fmt.Fprintf(&buf, "%d 0 obj\n", num)
+		dict["Length"] = len(data)
+		writeObject(&buf, dict, numbers)
+		buf.WriteString("\nstream\n")
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+		cw.Write(buf.Bytes())
+	}
+
+	var packed []int
+	for i, num := range order {
+		s, ok := d.Objects[num].(*Stream)
+		if !ok {
+			packed = append(packed, i+1)
+			continue
+		}
+
+		dict := Dict{}
+		for k, v := range s.Dict {
+			dict[k] = v
+		}
+		writeIndirect(i+1, dict, s.Data)
+	}
+
+	for chunk := range slices.Chunk(packed, objectsPerStream) {
+		objStmNum := len(entries)
+		entries = append(entries, xrefEntry{})
+
+		var header, body bytes.Buffer
+		for index, num := range chunk {
+			fmt.Fprintf(&header, "%d %d ", num, body.Len())
+			writeObject(&body, d.Objects[order[num-1]], numbers)
+			body.WriteByte('\n')
+			entries[num] = xrefEntry{kind: 2, field2: int64(objStmNum), field3: index}
+		}
+
+		writeIndirect(objStmNum, Dict{
+			"Type":   Name("ObjStm"),
+			"N":      len(chunk),
+			"First":  header.Len(),
+			"Filter": Name("FlateDecode"),
+		}, deflate(append(header.Bytes(), body.Bytes()...)))
+	}
+
+	xrefNum := len(entries)
+	entries = append(entries, xrefEntry{kind: 1, field2: cw.n})
+
+	var table bytes.Buffer
+	table.Write([]byte{0, 0, 0, 0, 0, 0xff, 0xff})
+	for _, e := range entries[1:] {
+		table.Write([]byte{
+			e.kind,
+			byte(e.field2 >> 24), byte(e.field2 >> 16), byte(e.field2 >> 8), byte(e.field2),
+			byte(e.field3 >> 8), byte(e.field3),
+		})
+	}
+
+	trailer := Dict{
+		"Type":   Name("XRef"),
+		"Size":   len(entries),
+		"W":      Array{1, 4, 2},
+		"Filter": Name("FlateDecode"),
+	}
+	for _, key := range []Name{"Root", "Info", "ID"} {
+		if v, ok := d.Trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	writeIndirect(xrefNum, trailer, deflate(table.Bytes()))
+	fmt.Fprintf(cw, "startxref\n%d\n%%%%EOF\n", entries[xrefNum].field2)
+
+	if cw.err != nil {
+		return cw.err
+	}
+	return bw.Flush()
+}
+
+// reachable walks the object graph from the trailer and assigns the new
+// object numbers. Objects nothing points to are dropped.
+func (d *Document) reachable() ([]int, map[int]int) {
+	var order []int
+	numbers := map[int]int{}
+
+	var visit func(obj Object)
+	visit = func(obj Object) {
+		switch v := obj.(type) {
+		case Ref:
+			target, ok := d.Objects[v.Num]
+			if _, seen := numbers[v.Num]; seen || !ok {
+				return
+			}
+			order = append(order, v.Num)
+			numbers[v.Num] = len(order)
+			visit(target)
+		case Array:
+			for _, e := range v {
+				visit(e)
+			}
+		case Dict:
+			for _, k := range sortedKeys(v) {
+				visit(v[k])
+			}
+		case *Stream:
+			visit(v.Dict)
+		}
+	}
+
+	for _, key := range []Name{"Root", "Info"} {
+		visit(d.Trailer[key])
+	}
+
+	return order, numbers
+}
+
+func sortedKeys(d Dict) []Name {
+	keys := make([]Name, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// writeObject serializes a direct object. References are renumbered with
+// numbers, or written as-is when numbers is nil; references to objects that
+// are not written become null.
+func writeObject(buf *bytes.Buffer, obj Object, numbers map[int]int) {
+	switch v := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case Name:
+		writeName(buf, v)
+	case String:
+		writeString(buf, v)
+	case Ref:
+		if numbers == nil {
+			fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+		} else if n, ok := numbers[v.Num]; ok {
+			fmt.Fprintf(buf, "%d 0 R", n)
+		} else {
+			buf.WriteString("null")
+		}
+	case Array:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, e, numbers)
+		}
+		buf.WriteByte(']')
+	case Dict:
+		buf.WriteString("<<")
+		for _, k := range sortedKeys(v) {
+			writeName(buf, k)
+			buf.WriteByte(' ')
+			writeObject(buf, v[k], numbers)
+		}
+		buf.WriteString(">>")
+	case *Stream:
+		writeObject(buf, v.Dict, numbers)
+	}
+}
+
+func writeName(buf *bytes.Buffer, n Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < '!' || c > '~' || c == '#' || isDelim(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func writeString(buf *bytes.Buffer, s String) {
+	binary := 0
+	for _, c := range s {
+		if c < 0x20 || c > 0x7e {
+			binary++
+		}
+	}
+
+	if binary*4 > len(s) {
+		fmt.Fprintf(buf, "<%X>", []byte(s))
+		return
+	}
+
+	buf.WriteByte('(')
+	for _, c := range s {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte(')')
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
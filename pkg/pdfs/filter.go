@@ -0,0 +1,296 @@
+package pdfs
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files that list paths to leave out, with the
+// same syntax as a .gitignore. Each one applies to the directory it is in.
+const IgnoreFile = ".pressgoignore"
+
+// Options narrow down which pdfs are found. The zero value finds all of them
+// outside hidden directories.
+type Options struct {
+	// Include, when not empty, keeps only the files matching one of its
+	// patterns.
+	Include []string
+	// Exclude leaves out the files and directories matching one of its
+	// patterns.
+	Exclude []string
+	// MaxDepth is how deep files are looked for, 1 being the directory
+	// itself. 0 means no limit.
+	MaxDepth int
+	// Hidden also looks inside directories whose name starts with a dot.
+	Hidden bool
+	// Extensionless also looks at files without an extension, which are
+	// kept when their content is a pdf.
+	Extensionless bool
+	// MinSize leaves out the pdfs smaller than it, in bytes.
+	MinSize int64
+}
+
+// Filter decides which paths under a root directory are looked at. Patterns
+// follow the .gitignore syntax: a pattern with a slash is relative to the
+// root, one without it matches the name at any depth, a trailing slash only
+// matches directories and ** matches any number of directories. Patterns of
+// ignore files are relative to their directory and a leading ! includes
+// again what an earlier pattern left out.
+//
+// A Filter is not safe for concurrent use.
+type Filter struct {
+	root    string
+	opts    Options
+	include []pattern
+	exclude []pattern
+	// ignores caches the patterns of the ignore file of each directory,
+	// relative to the root.
+	ignores map[string][]pattern
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// base is the directory the pattern is relative to, "" for the root.
+	base string
+}
+
+func NewFilter(root string, opts Options) (*Filter, error) {
+	f := &Filter{root: root, opts: opts, ignores: map[string][]pattern{}}
+
+	for _, p := range opts.Include {
+		compiled, ok, err := parsePattern(p, "")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			f.include = append(f.include, compiled)
+		}
+	}
+	for _, p := range opts.Exclude {
+		compiled, ok, err := parsePattern(p, "")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			f.exclude = append(f.exclude, compiled)
+		}
+	}
+
+	return f, nil
+}
+
+// SkipDir reports whether the directory name, and everything in it, is
+// left out.
+func (f *Filter) SkipDir(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok || rel == "." {
+		return false
+	}
+
+	if !f.opts.Hidden && strings.HasPrefix(filepath.Base(rel), ".") {
+		return true
+	}
+	// Files directly inside the directory would be one level deeper.
+	if f.opts.MaxDepth > 0 && depth(rel)+1 > f.opts.MaxDepth {
+		return true
+	}
+	return f.ignored(rel, true)
+}
+
+// Match reports whether the file name is kept. Files outside of the root
+// are always kept.
+func (f *Filter) Match(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok {
+		return true
+	}
+
+	if f.opts.MaxDepth > 0 && depth(rel) > f.opts.MaxDepth {
+		return false
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if !f.opts.Hidden && strings.HasPrefix(path.Base(dir), ".") {
+			return false
+		}
+		if f.ignored(dir, true) {
+			return false
+		}
+	}
+	if f.ignored(rel, false) {
+		return false
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// rel returns name relative to the root, with forward slashes.
+func (f *Filter) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(f.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// ignored applies the exclude patterns and then the ignore files from the
+// root down to the directory of rel; the last pattern that matches wins.
+func (f *Filter) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range f.exclude {
+		if p.match(rel, isDir) {
+			ignored = true
+		}
+	}
+
+	dirs := []string{""}
+	if dir := path.Dir(rel); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, dir := range dirs {
+		for _, p := range f.ignoreFile(dir) {
+			if p.match(rel, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// ignoreFile returns the patterns of the ignore file in dir, relative to the
+// root. A missing or unreadable file has no patterns.
+func (f *Filter) ignoreFile(dir string) []pattern {
+	if patterns, ok := f.ignores[dir]; ok {
+		return patterns
+	}
+
+	var patterns []pattern
+	file, err := os.Open(filepath.Join(f.root, filepath.FromSlash(dir), IgnoreFile))
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// Invalid patterns are skipped, like git does.
+			if p, ok, err := parsePattern(scanner.Text(), dir); err == nil && ok {
+				patterns = append(patterns, p)
+			}
+		}
+		file.Close()
+	}
+
+	f.ignores[dir] = patterns
+	return patterns
+}
+
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(rel, p.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, p.base+"/")
+	}
+	return p.re.MatchString(rel)
+}
+
+// parsePattern compiles a line of an ignore file. It returns false for blank
+// lines and comments.
+func parsePattern(line, base string) (pattern, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false, nil
+	}
+
+	p := pattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false, nil
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pattern{}, false, err
+	}
+	p.re = re
+	return p, true, nil
+}
+
+// globToRegexp translates a glob where * and ? don't cross slashes and **
+// matches any number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+func depth(rel string) int {
+	return strings.Count(rel, "/") + 1
+}
@@ -6,41 +6,157 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 )
 
-func GetFromRoute(pdfsDirPath string) ([]string, error) {
+// File is a pdf, or an image, found on disk.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Version is the pdf version written in the header, like 1.7.
+	Version string
+	// Format is the format of an image, jpeg or png.
+	Format string
+}
+
+// GetFromRoute returns the pdfs directly inside pdfsDirPath and the files
+// there that have a .pdf extension but aren't pdfs.
+func GetFromRoute(pdfsDirPath string, opts Options) ([]File, []string, error) {
 	entries, err := os.ReadDir(pdfsDirPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	filter, err := NewFilter(pdfsDirPath, opts)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	pdfs := []string{}
+	c := collector{sniff: Sniff, claims: isPDFExt}
 	for _, e := range entries {
-		if !e.IsDir() && strings.ToLower(filepath.Ext(e.Name())) == ".pdf" {
-			pdfs = append(pdfs, e.Name())
+		path := filepath.Join(pdfsDirPath, e.Name())
+		if e.IsDir() || !filter.Match(path) {
+			continue
+		}
+		if err := c.add(path, e, opts); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	return pdfs, err
+	return c.result()
 }
 
-func GetFromDir(dir string) ([]string, error) {
-	pdfs := []string{}
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+// GetFromDir returns the pdfs under dir and the files that have a .pdf
+// extension but aren't pdfs.
+func GetFromDir(dir string, opts Options) ([]File, []string, error) {
+	c := collector{sniff: Sniff, claims: isPDFExt}
+	if err := walk(dir, opts, &c); err != nil {
+		return nil, nil, err
+	}
+	return c.result()
+}
+
+// GetImagesFromDir returns the jpeg and png images under dir and the files
+// that have the extension of one but aren't.
+func GetImagesFromDir(dir string, opts Options) ([]File, []string, error) {
+	c := collector{sniff: SniffImage, claims: isImageExt, images: true}
+	if err := walk(dir, opts, &c); err != nil {
+		return nil, nil, err
+	}
+	return c.result()
+}
+
+// walk adds to c the files under dir that opts keeps.
+func walk(dir string, opts Options, c *collector) error {
+	filter, err := NewFilter(dir, opts)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !d.IsDir() && strings.EqualFold(filepath.Ext(d.Name()), ".pdf") {
-			pdfs = append(pdfs, path)
+		if d.IsDir() {
+			if filter.SkipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		return nil
+		if !filter.Match(path) {
+			return nil
+		}
+		return c.add(path, d, opts)
 	})
+}
+
+type collector struct {
+	// sniff tells the format of a file by its content and claims whether
+	// an extension says a file has it.
+	sniff  func(path string) (string, bool, error)
+	claims func(ext string) bool
+	// images records the format as an image format instead of a version.
+	images  bool
+	files   []File
+	invalid []string
+}
+
+func isPDFExt(ext string) bool {
+	return strings.EqualFold(ext, ".pdf")
+}
+
+// add sniffs the file if its extension says it has the format looked for, or
+// it has none and opts allows it.
+func (c *collector) add(path string, d fs.DirEntry, opts Options) error {
+	ext := filepath.Ext(d.Name())
+	claimed := c.claims(ext)
+	if !claimed && (ext != "" || !opts.Extensionless) {
+		return nil
+	}
+	if !d.Type().IsRegular() {
+		return nil
+	}
+
+	format, ok, err := c.sniff(path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if !ok {
+		if claimed {
+			c.invalid = append(c.invalid, path)
+		}
+		return nil
 	}
-	slices.Sort(pdfs)
 
-	return pdfs, nil
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	if info.Size() < opts.MinSize {
+		return nil
+	}
+
+	file := File{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	if c.images {
+		file.Format = format
+	} else {
+		file.Version = format
+	}
+	c.files = append(c.files, file)
+	return nil
+}
+
+func (c *collector) result() ([]File, []string, error) {
+	slices.SortFunc(c.files, func(a, b File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	slices.Sort(c.invalid)
+
+	if c.files == nil {
+		c.files = []File{}
+	}
+	return c.files, c.invalid, nil
 }
@@ -0,0 +1,230 @@
+package pdfs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const minimalPDF = "%PDF-1.7\n%%EOF\n"
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func relPaths(t *testing.T, dir string, files []File) []string {
+	t.Helper()
+	rel := []string{}
+	for _, file := range files {
+		r, err := filepath.Rel(dir, file.Path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	return rel
+}
+
+func TestGetFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.pdf":                    minimalPDF,
+		"B.PDF":                    minimalPDF,
+		"notes.txt":                "",
+		".git/objects/c.pdf":       minimalPDF,
+		"vendor/lib/d.pdf":         minimalPDF,
+		"cases/one/e.pdf":          minimalPDF,
+		"cases/one/draft-e.pdf":    minimalPDF,
+		"cases/two/f.pdf":          minimalPDF,
+		"cases/two/keep.pdf":       minimalPDF,
+		"cases/two/.pressgoignore": "*.pdf\n!keep.pdf\n",
+		".pressgoignore":           "# vendored files\nvendor/\ndraft-*\n",
+	})
+
+	tests := map[string]struct {
+		opts Options
+		want []string
+	}{
+		"defaults": {
+			Options{},
+			[]string{"B.PDF", "a.pdf", "cases/one/e.pdf", "cases/two/keep.pdf"},
+		},
+		"hidden": {
+			Options{Hidden: true},
+			[]string{".git/objects/c.pdf", "B.PDF", "a.pdf", "cases/one/e.pdf", "cases/two/keep.pdf"},
+		},
+		"max depth": {
+			Options{MaxDepth: 1},
+			[]string{"B.PDF", "a.pdf"},
+		},
+		"include": {
+			Options{Include: []string{"cases/**/*.pdf"}},
+			[]string{"cases/one/e.pdf", "cases/two/keep.pdf"},
+		},
+		"exclude": {
+			Options{Exclude: []string{"one/", "[ab].pdf"}},
+			[]string{"B.PDF", "cases/two/keep.pdf"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, _, err := GetFromDir(dir, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rel := relPaths(t, dir, got); !slices.Equal(rel, tt.want) {
+				t.Errorf("got %q, want %q", rel, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFromRoute(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.pdf":          minimalPDF,
+		"b.pdf":          minimalPDF,
+		"sub/c.pdf":      minimalPDF,
+		".pressgoignore": "b.pdf\n",
+	})
+
+	got, _, err := GetFromRoute(dir, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a.pdf"}; !slices.Equal(relPaths(t, dir, got), want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFilter_Patterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.pdf", "a.pdf", true},
+		{"*.pdf", "x/y/a.pdf", true},
+		{"/a.pdf", "x/a.pdf", false},
+		{"x/a.pdf", "x/a.pdf", true},
+		{"x/a.pdf", "y/x/a.pdf", false},
+		{"**/x/a.pdf", "y/x/a.pdf", true},
+		{"x/**", "x/y/a.pdf", true},
+		{"x/**/a.pdf", "x/a.pdf", true},
+		{"x/**/a.pdf", "x/y/z/a.pdf", true},
+		{"?.pdf", "ab.pdf", false},
+		{"[!a].pdf", "b.pdf", true},
+		{"[!a].pdf", "a.pdf", false},
+		{`\#a.pdf`, "#a.pdf", true},
+	}
+
+	for _, tt := range tests {
+		f, err := NewFilter("/root", Options{Exclude: []string{tt.pattern}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := f.ignored(tt.path, false); got != tt.want {
+			t.Errorf("pattern %q on %q: got %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGetFromDir_Content(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"real.pdf":     minimalPDF,
+		"late.pdf":     strings.Repeat(" ", 1000) + "%PDF-1.4\n",
+		"too-late.pdf": strings.Repeat(" ", 1020) + "%PDF-1.4\n",
+		"fake.pdf":     "<html>not a pdf</html>",
+		"scan":         "%PDF-2.0\n",
+		"notes":        "plain text",
+		"renamed.txt":  minimalPDF,
+	})
+
+	got, invalid, err := GetFromDir(dir, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"late.pdf", "real.pdf"}; !slices.Equal(relPaths(t, dir, got), want) {
+		t.Errorf("got %q, want %q", relPaths(t, dir, got), want)
+	}
+	if got[0].Version != "1.4" || got[1].Version != "1.7" || got[1].Size != int64(len(minimalPDF)) || got[1].ModTime.IsZero() {
+		t.Errorf("got entries %+v", got)
+	}
+	if want := []string{filepath.Join(dir, "fake.pdf"), filepath.Join(dir, "too-late.pdf")}; !slices.Equal(invalid, want) {
+		t.Errorf("got invalid %q, want %q", invalid, want)
+	}
+
+	got, _, err = GetFromDir(dir, Options{Extensionless: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"late.pdf", "real.pdf", "scan"}; !slices.Equal(relPaths(t, dir, got), want) {
+		t.Errorf("got %q, want %q", relPaths(t, dir, got), want)
+	}
+	if got[2].Version != "2.0" {
+		t.Errorf("got version %q, want 2.0", got[2].Version)
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	const plain = "%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n"
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"plain.pdf":     plain,
+		"encrypted.pdf": strings.Replace(plain, "/Root 1 0 R", "/Root 1 0 R /Encrypt 2 0 R", 1),
+		"broken.pdf":    minimalPDF,
+	})
+
+	for name, want := range map[string]bool{"plain.pdf": false, "encrypted.pdf": true, "broken.pdf": false} {
+		got, err := Encrypted(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("got %v for %s, want %v", got, name, want)
+		}
+	}
+
+	if _, err := Encrypted(filepath.Join(dir, "missing.pdf")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestGetImagesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"photo.JPG":        "\xff\xd8\xff\xe0 jfif",
+		"scan.png":         "\x89PNG\r\n\x1a\n data",
+		"fake.jpeg":        "<html>not an image</html>",
+		"doc.pdf":          minimalPDF,
+		"sub/page.jpg":     "\xff\xd8\xff\xe1 exif",
+		".hidden/skip.jpg": "\xff\xd8\xff\xe0",
+	})
+
+	got, invalid, err := GetImagesFromDir(dir, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"photo.JPG", "scan.png", "sub/page.jpg"}; !slices.Equal(relPaths(t, dir, got), want) {
+		t.Errorf("got %q, want %q", relPaths(t, dir, got), want)
+	}
+	if got[0].Format != JPEG || got[1].Format != PNG || got[0].Version != "" {
+		t.Errorf("got entries %+v", got)
+	}
+	if want := []string{filepath.Join(dir, "fake.jpeg")}; !slices.Equal(invalid, want) {
+		t.Errorf("got invalid %q, want %q", invalid, want)
+	}
+}
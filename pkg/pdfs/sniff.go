@@ -0,0 +1,104 @@
+package pdfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fernando8franco/pressgo/pkg/pdfdoc"
+)
+
+// sniffLen is how far into a file the pdf header is looked for. Some
+// producers write a few bytes before it and readers accept up to 1024.
+const sniffLen = 1024
+
+var header = []byte("%PDF-")
+
+// Sniff reports whether the file at path is a pdf, going by its content, and
+// the pdf version written in its header.
+func Sniff(path string) (string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen+len("%PDF-x.y"))
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", false, err
+	}
+	buf = buf[:n]
+
+	i := bytes.Index(buf, header)
+	if i < 0 || i > sniffLen-len(header) {
+		return "", false, nil
+	}
+
+	version := buf[i+len(header):]
+	end := 0
+	for end < len(version) && (version[end] == '.' || '0' <= version[end] && version[end] <= '9') {
+		end++
+	}
+	return string(version[:end]), true, nil
+}
+
+// Encrypted reports whether the pdf at path is password protected, going by
+// the /Encrypt entry of its trailer. Files pdfdoc can't parse are reported as
+// not encrypted and left for the tools to reject.
+func Encrypted(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	doc, err := pdfdoc.Parse(data)
+	if err != nil {
+		return false, nil
+	}
+	return doc.Encrypted(), nil
+}
+
+// Image formats told apart by SniffImage.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+)
+
+var imageHeaders = map[string][]byte{
+	JPEG: {0xff, 0xd8, 0xff},
+	PNG:  []byte("\x89PNG\r\n\x1a\n"),
+}
+
+func isImageExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// SniffImage reports whether the file at path is a jpeg or png image, going
+// by its content, and which of them it is.
+func SniffImage(path string) (string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, len(imageHeaders[PNG]))
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", false, err
+	}
+	buf = buf[:n]
+
+	for _, format := range []string{JPEG, PNG} {
+		if bytes.HasPrefix(buf, imageHeaders[format]) {
+			return format, true, nil
+		}
+	}
+	return "", false, nil
+}